@@ -19,6 +19,7 @@ import (
 	"net/http"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/zenazn/goji"
@@ -39,11 +40,21 @@ type Options struct {
 	// code, but retrieved securely on startup from a config file or environment variable.
 	JwtKey string
 
+	// SigningKeys optionally replaces JwtKey for signing and verifying the access tokens issued by
+	// the login route, so that other services can verify them without holding a secret. The first key
+	// with a PrivateKey signs new tokens, and tokens signed by any of the keys are accepted, so keys can
+	// be rotated by adding a new key to the front and removing the old one once its tokens have expired.
+	// The public keys are published at /.well-known/jwks.json (route it to Grapi to serve it).
+	SigningKeys []SigningKey
+
 	// If you are going to UseDefaultAuth then you also need to provide an object which satisfies LoginModel. This
 	// provides us with callbacks that will be used to check login credentials, and to retrieve the User model
 	// for passing to subsequent callbacks.
 	LoginModel LoginModel
 
+	// SubjectLoginModel can be given instead of LoginModel for users with string ids, such as UUIDs.
+	SubjectLoginModel SubjectLoginModel
+
 	// UriPrefix is optional and defaults to api. The REST routes for a model called ModelName will be found by
 	// default at /UriModelName/model_names . A single leading or trailing slash on UriPrefix will be ignored.
 	// Note that you will have to also tell your router to route http requests for routes starting with UriPrefix
@@ -56,6 +67,35 @@ type Options struct {
 
 	// LogLevel. 0 is default (errors). -1 means silent. 1 means everything.
 	LogLevel int
+
+	// DefaultPageSize is the number of items returned by index routes when the client
+	// doesn't ask for a page size with ?limit= or ?per_page=. 0 means return everything.
+	// MaxPageSize caps the page size a client may ask for. 0 means no limit. Both can be
+	// overridden for a single route in RouteOptions.
+	DefaultPageSize int
+	MaxPageSize     int
+
+	// ProblemWriter optionally replaces the function used to send errors to the client.
+	// By default a Problem is sent as RFC 7807 application/problem+json.
+	ProblemWriter ProblemWriter
+
+	// IdempotencyWindow is how long the responses to requests with an Idempotency-Key
+	// header are kept for replaying (see RouteOptions.UseIdempotencyKey). By default 24 hours.
+	IdempotencyWindow time.Duration
+
+	// AccessTokenTTL is how long the tokens issued by the login route (see SetAuth) are
+	// valid for. By default 1 hour.
+	AccessTokenTTL time.Duration
+
+	// If RefreshTokenTTL is set then the login route also issues a refresh token, valid
+	// for this long, which can be exchanged for a new access token. Refresh tokens are
+	// kept in RefreshTokenStore, by default the grapi_refresh_tokens table.
+	RefreshTokenTTL   time.Duration
+	RefreshTokenStore RefreshTokenStore
+
+	// RevocationList keeps the access tokens that have been revoked by logging out or
+	// RevokeAllSessions. By default it is kept in the database.
+	RevocationList RevocationList
 }
 
 // Grapi is an http handler which handles REST requests for objects it has been
@@ -81,6 +121,13 @@ func New(o Options) *Grapi {
 		o.UriPrefix = "/" + o.UriPrefix
 	}
 	o.UriPrefix = strings.TrimSuffix(o.UriPrefix, "/")
+	if o.IdempotencyWindow == 0 {
+		o.IdempotencyWindow = defaultIdempotencyWindow
+	}
+	if o.AccessTokenTTL == 0 {
+		o.AccessTokenTTL = time.Hour
+	}
+	o.initSigningKeys()
 
 	gj := web.New()
 	gj.Use(middleware.RequestID)
@@ -111,13 +158,15 @@ func (g *Grapi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // then we will add the following routes:
 //   * GET /api/secret_widgets  - Return a list of all SecretWidget objects
 //   * GET /api/secret_widgets/:id  - Return SecretWidget with ID==:id, or 404 Not Found
-//   * POST /api/secret_widgets  - Upload a new SecretWidget to the database, or return 422 if posted json doesn't parse
+//   * POST /api/secret_widgets  - Upload a new SecretWidget to the database and return 201 Created, or return 422 if posted json doesn't parse
+//   * PUT /api/secret_widgets/:id  - Replace SecretWidget with ID==:id, or return 422 or 404 on error
 //   * PATCH /api/secret_widgets/:id  - Update SecretWidget with ID==:id, or return 422 or 404 on error
 //   * DELETE /api/secret_widgets/:id  - Delete the SecretWidget with ID==:id
+//   * POST /api/secret_widgets/:id/restore  - Restore a soft deleted SecretWidget, if it has a DeletedAt field
 //
 // options is optional. If two options arguments
-// are given then the first will apply to GET routes, and the second to POST/PATCH/DELETE
-// If three are given then the third applies to DELETE routes
+// are given then the first will apply to GET routes, and the second to POST/PUT/PATCH/DELETE (and restore)
+// If three are given then the third applies to DELETE and restore routes
 func (g *Grapi) AddDefaultRoutes(modelPtr interface{}, options ...RouteOptions) {
 	var viewOption *RouteOptions
 	if len(options) > 3 {
@@ -137,8 +186,12 @@ func (g *Grapi) AddDefaultRoutes(modelPtr interface{}, options ...RouteOptions)
 	g.AddGetRoute(modelPtr, viewOption)
 	g.AddIndexRoute(modelPtr, viewOption)
 	g.AddPostRoute(modelPtr, editOption)
+	g.AddPutRoute(modelPtr, editOption)
 	g.AddPatchRoute(modelPtr, editOption)
 	g.AddDeleteRoute(modelPtr, deleteOption)
+	if g.deletedAtColumn(reflect.TypeOf(modelPtr).Elem()) != "" {
+		g.AddRestoreRoute(modelPtr, deleteOption)
+	}
 }
 
 // Adds a route at "#{g.prefix}/#{pluralmodelname}/:id" to get item. ie. g.AddGetRoute(&Widget{}, nil)
@@ -178,6 +231,10 @@ func (g *Grapi) AddPostRoute(modelP interface{}, ro *RouteOptions) {
 	path := g.makePath(modelP, ro)
 	modelType := reflect.TypeOf(modelP).Elem()
 	log.WithFields(log.Fields{"Model": modelType, "path": path}).Info("Adding POST route")
+	if ro.Bulk {
+		g.router.Post(path, g.bulkPostHandler(modelType, ro))
+		return
+	}
 	g.router.Post(path, g.postHandler(modelType, ro))
 }
 
@@ -192,6 +249,22 @@ func (g *Grapi) AddPatchRoute(modelP interface{}, ro *RouteOptions) {
 	modelType := reflect.TypeOf(modelP).Elem()
 	log.WithFields(log.Fields{"Model": modelType, "path": path}).Info("Adding PATCH route")
 	g.router.Patch(path, g.patchHandler(modelType, ro))
+	if ro.Bulk {
+		g.router.Patch(g.makePath(modelP, ro), g.bulkPatchHandler(modelType, ro))
+	}
+}
+
+// Adds a route at "#{g.prefix}/#{pluralmodelname}/:id" to replace an item. ie. g.AddPutRoute(&Widget{}, nil)
+// by default adds a route such that PUT "/api/widgets/2" replaces the widget with id==2
+func (g *Grapi) AddPutRoute(modelP interface{}, ro *RouteOptions) {
+	if ro == nil {
+		ro = &RouteOptions{}
+	}
+	ro.Initialise(g)
+	path := g.makePath(modelP, ro) + "/:id"
+	modelType := reflect.TypeOf(modelP).Elem()
+	log.WithFields(log.Fields{"Model": modelType, "path": path}).Info("Adding PUT route")
+	g.router.Put(path, g.putHandler(modelType, ro))
 }
 
 // Adds a route at "#{g.prefix}/#{pluralmodelname}/:id" to delete item. ie. g.AddGetRoute(&Widget{}, nil)
@@ -205,41 +278,86 @@ func (g *Grapi) AddDeleteRoute(modelP interface{}, ro *RouteOptions) {
 	modelType := reflect.TypeOf(modelP).Elem()
 	log.WithFields(log.Fields{"Model": modelType, "path": path}).Info("Adding DELETE route")
 	g.router.Delete(path, g.deleteHandler(modelType, ro))
+	if ro.Bulk {
+		g.router.Delete(g.makePath(modelP, ro), g.bulkDeleteHandler(modelType, ro))
+	}
 }
 
 // itemHandler returns a goji handler that gets a single item from the database and returns it.
 // Depending on the callbacks set in RouteOptions, before querying the database we may authenticate,
 // authorize, and scope the request. After query the result may be edited before sending back to
-// client. 404/422/500 etc are sent as appropriate on error.
+// client. 404/422/500 etc are sent as appropriate on error. The client can ask for only
+// some of the item's fields with ?fields= (see SelectFields), and for associations to be
+// loaded with ?include= (see IncludeAssociations). The ETag and Last-Modified headers
+// are set, and 304 Not Modified is returned if the client already has the item.
 func (g *Grapi) itemHandler(itemType reflect.Type, o *RouteOptions) web.HandlerType {
 	tableName := pluralCamelNameType(itemType)
+	columns := g.getModelFields(itemType)
+	deletedAt := g.deletedAtColumn(itemType)
+	o.versionField(columns) // Check the version field exists now rather than at request time
+	includes := o.includePaths(g, itemType)
 	return func(c web.C, w http.ResponseWriter, r *http.Request) {
-		req := request{api: g, DB: g.db, method: "GET", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o}
+		req := request{api: g, DB: g.db, method: "GET", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o,
+			columns: columns, includes: includes, deletedAt: deletedAt}
 		if true &&
 			(o.Authenticate == nil || o.Authenticate(&req)) &&
 			(o.Authorize == nil || o.Authorize(&req)) &&
 			(o.Query == nil || o.Query(&req)) &&
+			req.ScopeDeleted() &&
+			req.IncludeAssociations() &&
+			req.SelectFields() &&
 			req.GetItemById() &&
+			req.SetETag() &&
+			req.SetLastModified() &&
 			(o.EditResult == nil || o.EditResult(&req)) &&
+			req.StripFields() &&
 			req.SerialiseResult() {
 			log.WithFields(log.Fields{"Model": itemType}).Info("Successful GET")
+		} else {
+			req.writeFailure()
 		}
 	}
 }
 
-// indexHandler does the same as itemHandler, but for a full list of items.
+// indexHandler does the same as itemHandler, but for a full list of items. The list
+// may be filtered by query parameters (see FilterItems), paginated (see Paginate),
+// sorted (see SortItems), narrowed to some fields (see SelectFields), and have their
+// associations loaded (see IncludeAssociations). As with items a 304 may be returned,
+// using an ETag made from a hash of the list.
 func (g *Grapi) indexHandler(sliceType reflect.Type, o *RouteOptions) web.HandlerType {
 	tableName := pluralCamelNameType(sliceType)
+	columns := g.getModelFields(sliceType)
+	deletedAt := g.deletedAtColumn(sliceType)
+	if o.CursorPagination {
+		if g.options.JwtKey == "" {
+			panic("Can't sign pagination cursors unless you provide a random secret string as JwtKey parameter of api.New()")
+		}
+		o.cursorColumns(columns) // Check the cursor field exists now rather than at request time
+	}
+	o.checkDefaultSort(columns)
+	includes := o.includePaths(g, sliceType)
 	return func(c web.C, w http.ResponseWriter, r *http.Request) {
-		req := request{api: g, DB: g.db, method: "GET", C: c, W: w, R: r, Type: sliceType, TableName: tableName, options: o}
+		req := request{api: g, DB: g.db, method: "GET", C: c, W: w, R: r, Type: sliceType, TableName: tableName, options: o,
+			columns: columns, includes: includes, deletedAt: deletedAt}
 		if true &&
 			(o.Authenticate == nil || o.Authenticate(&req)) &&
 			(o.Authorize == nil || o.Authorize(&req)) &&
 			(o.Query == nil || o.Query(&req)) &&
+			req.ScopeDeleted() &&
+			req.FilterItems() &&
+			req.Paginate() &&
+			req.SortItems() &&
+			req.IncludeAssociations() &&
+			req.SelectFields() &&
 			req.GetItems() &&
+			req.TrimCursorPage() &&
 			(o.EditResult == nil || o.EditResult(&req)) &&
+			req.StripFields() &&
+			req.AddPaginationMeta() &&
 			req.SerialiseResult() {
 			log.WithFields(log.Fields{"Model": sliceType}).Info("Successful index GET")
+		} else {
+			req.writeFailure()
 		}
 	}
 }
@@ -247,17 +365,30 @@ func (g *Grapi) indexHandler(sliceType reflect.Type, o *RouteOptions) web.Handle
 // postHandler returns a handler for posting a new item to the database.
 func (g *Grapi) postHandler(itemType reflect.Type, o *RouteOptions) web.HandlerType {
 	tableName := pluralCamelNameType(itemType)
+	columns := g.getModelFields(itemType)
+	if o.UseIdempotencyKey {
+		g.migrateIdempotencyKeys()
+	}
 	return func(c web.C, w http.ResponseWriter, r *http.Request) {
-		req := request{api: g, DB: g.db, method: "POST", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o}
+		req := request{api: g, DB: g.db, method: "POST", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o,
+			columns: columns}
+		defer req.rollbackTx()
+		defer req.saveIdempotentResponse()
 		if true &&
 			(o.Authenticate == nil || o.Authenticate(&req)) &&
 			(o.Authorize == nil || o.Authorize(&req)) &&
+			req.CheckIdempotencyKey() &&
+			req.BeginTx() &&
 			req.ParseUpload() &&
 			(o.CheckUpload == nil || o.CheckUpload(&req)) &&
 			req.PostDB() &&
+			req.SetCreated() &&
 			(o.EditResult == nil || o.EditResult(&req)) &&
+			req.CommitTx() &&
 			req.SerialiseResult() {
 			log.WithFields(log.Fields{"Model": itemType}).Info("Successful POST")
+		} else {
+			req.writeFailure()
 		}
 	}
 }
@@ -265,19 +396,65 @@ func (g *Grapi) postHandler(itemType reflect.Type, o *RouteOptions) web.HandlerT
 // patchHandler returns a handler for editing items in the database
 func (g *Grapi) patchHandler(itemType reflect.Type, o *RouteOptions) web.HandlerType {
 	tableName := pluralCamelNameType(itemType)
+	columns := g.getModelFields(itemType)
+	o.versionField(columns)
+	if o.UseIdempotencyKey {
+		g.migrateIdempotencyKeys()
+	}
 	return func(c web.C, w http.ResponseWriter, r *http.Request) {
-		req := request{api: g, DB: g.db, method: "PATCH", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o}
+		req := request{api: g, DB: g.db, method: "PATCH", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o,
+			columns: columns}
+		defer req.rollbackTx()
+		defer req.saveIdempotentResponse()
 		if true &&
 			(o.Authenticate == nil || o.Authenticate(&req)) &&
 			(o.Authorize == nil || o.Authorize(&req)) &&
+			req.CheckIdempotencyKey() &&
+			req.BeginTx() &&
 			(o.Query == nil || o.Query(&req)) &&
 			req.GetItemById() &&
+			req.CheckIfMatch() &&
 			req.PatchResultWithUploaded() &&
 			(o.CheckUpload == nil || o.CheckUpload(&req)) &&
 			req.PatchDB() &&
+			req.SetETag() &&
 			(o.EditResult == nil || o.EditResult(&req)) &&
+			req.CommitTx() &&
 			req.SerialiseResult() {
 			log.WithFields(log.Fields{"Model": itemType}).Info("Successful PATCH")
+		} else {
+			req.writeFailure()
+		}
+	}
+}
+
+// putHandler returns a handler for replacing items in the database
+func (g *Grapi) putHandler(itemType reflect.Type, o *RouteOptions) web.HandlerType {
+	tableName := pluralCamelNameType(itemType)
+	columns := g.getModelFields(itemType)
+	o.versionField(columns)
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		req := request{api: g, DB: g.db, method: "PUT", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o,
+			columns: columns}
+		defer req.rollbackTx()
+		if true &&
+			(o.Authenticate == nil || o.Authenticate(&req)) &&
+			(o.Authorize == nil || o.Authorize(&req)) &&
+			req.BeginTx() &&
+			(o.Query == nil || o.Query(&req)) &&
+			req.GetItemForPut() &&
+			req.CheckIfMatch() &&
+			req.ReplaceResultWithUploaded() &&
+			(o.CheckUpload == nil || o.CheckUpload(&req)) &&
+			req.PutDB() &&
+			req.SetCreated() &&
+			req.SetETag() &&
+			(o.EditResult == nil || o.EditResult(&req)) &&
+			req.CommitTx() &&
+			req.SerialiseResult() {
+			log.WithFields(log.Fields{"Model": itemType}).Info("Successful PUT")
+		} else {
+			req.writeFailure()
 		}
 	}
 }
@@ -285,26 +462,41 @@ func (g *Grapi) patchHandler(itemType reflect.Type, o *RouteOptions) web.Handler
 // deleteHandler returns a handler for deleting items from the database.
 func (g *Grapi) deleteHandler(itemType reflect.Type, o *RouteOptions) web.HandlerType {
 	tableName := pluralCamelNameType(itemType)
+	columns := g.getModelFields(itemType)
+	deletedAt := g.deletedAtColumn(itemType)
+	o.versionField(columns)
 	return func(c web.C, w http.ResponseWriter, r *http.Request) {
-		req := request{api: g, DB: g.db, method: "DELETE", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o}
+		req := request{api: g, DB: g.db, method: "DELETE", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o,
+			columns: columns, deletedAt: deletedAt}
+		defer req.rollbackTx()
 		if true &&
 			(o.Authenticate == nil || o.Authenticate(&req)) &&
 			(o.Authorize == nil || o.Authorize(&req)) &&
+			req.BeginTx() &&
 			(o.Query == nil || o.Query(&req)) &&
+			req.CheckHardDelete() &&
 			req.GetItemById() &&
+			req.CheckIfMatch() &&
 			req.DeleteFromDB() &&
 			(o.EditResult == nil || o.EditResult(&req)) &&
+			req.CommitTx() &&
 			req.SerialiseResult() {
 			log.WithFields(log.Fields{"Model": itemType}).Info("Successful DELETE")
+		} else {
+			req.writeFailure()
 		}
 	}
 }
 
 // makePath returns the path for the item, modidified as required by any options.
 func (g *Grapi) makePath(modelP interface{}, ro *RouteOptions) string {
-	path := ro.UriModelName
-	if len(path) == 0 {
-		path = pluralCamelName(modelP)
+	return g.options.UriPrefix + ro.Prefix + "/" + g.modelName(modelP, ro)
+}
+
+// modelName returns the name of the model used in its uri.
+func (g *Grapi) modelName(modelP interface{}, ro *RouteOptions) string {
+	if len(ro.UriModelName) > 0 {
+		return ro.UriModelName
 	}
-	return g.options.UriPrefix + ro.Prefix + "/" + path
+	return pluralCamelName(modelP)
 }
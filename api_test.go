@@ -38,7 +38,7 @@ func TestReadWriteOptions(t *testing.T) {
 	testReq(t, "ReadOnly(WRITE)", "POST", "/api/trwo_ro", `{"name":"sqlinjector"}`, 401)
 	testReq(t, "ReadOnly(DELETE)", "DELETE", "/api/trwo_ro/1", "", 401)
 	testReq(t, "ReadWrite(Read)", "GET", "/api/trwo_rw", "", 200)
-	testReq(t, "ReadWrite(WRITE)", "POST", "/api/trwo_rw", `{"name":"important widget"}`, 200)
+	testReq(t, "ReadWrite(WRITE)", "POST", "/api/trwo_rw", `{"name":"important widget"}`, 201)
 	testReq(t, "ReadWrite(DELETE)", "DELETE", "/api/trwo_rw/1", "", 401)
 
 	defer ensurePanic(t, "AddDefaultRoute accepted 4 route options")
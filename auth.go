@@ -2,10 +2,10 @@ package grapi
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/zenazn/goji/web"
 	"gopkg.in/dgrijalva/jwt-go.v2"
 
 	log "github.com/Sirupsen/logrus"
@@ -20,87 +20,160 @@ type LoginModel interface {
 	GetById(id uint, g *Grapi) (LoginModel, error)
 }
 
+// A LoginModel can also implement ClaimsLoginModel to add its own claims (eg. roles,
+// tenant or scopes) to the tokens issued at login. Callbacks can read them with
+// req.GetClaims(), and the logged in user is built from them by FromClaims rather
+// than loaded with GetById on every request.
+type ClaimsLoginModel interface {
+	LoginModel
+
+	//Claims returns the extra claims for the token of the user with this id. It is called
+	//at login and on every refresh. The id and registered claims (sub, jti, iat, exp etc.) can't be changed.
+	Claims(id uint, g *Grapi) (map[string]interface{}, error)
+
+	//FromClaims returns the LoginModel for the user with this id from the claims of their
+	//token. As the claims have been through json, numbers are float64 and lists []interface{}.
+	FromClaims(id uint, claims map[string]interface{}, g *Grapi) (LoginModel, error)
+}
+
+// registeredClaims are the claims set by grapi, which ClaimsLoginModel.Claims can't override.
+var registeredClaims = map[string]bool{
+	"sub": true, "jti": true, "iat": true, "iat_us": true, "exp": true, "nbf": true, "iss": true, "aud": true,
+}
+
 // SetAuth sets the model used for logging in. Path will be added as a
 // POST route to this model, with the LoginModel's AuthenticateJson method
-// called in the handler to determine if authentication passes.
+// called in the handler to determine if authentication passes. A POST to
+// path + "/logout" revokes the access token it is sent with (see RevocationList).
+// If Options.RefreshTokenTTL is set then path + "/refresh" is also added, to
+// exchange a refresh token for a new access token.
 func (g *Grapi) SetAuth(model LoginModel, path string) {
-	if g.options.JwtKey == "" {
-		panic("Can't do authorisation safely unless you provide a random secret string as JwtKey parameter of api.New()")
-	}
+	g.checkAuthKeys()
 	g.options.LoginModel = model
+	g.addAuthRoutes(path)
+}
+
+// checkAuthKeys panics if there are no keys to sign tokens with.
+func (g *Grapi) checkAuthKeys() {
+	if g.options.JwtKey == "" && len(g.options.SigningKeys) == 0 {
+		panic("Can't do authorisation safely unless you provide a random secret string as JwtKey parameter of api.New(), or SigningKeys")
+	}
+}
+
+// addAuthRoutes adds the login route at path, and the routes that go with it.
+func (g *Grapi) addAuthRoutes(path string) {
 	loginPath := g.options.UriPrefix + "/" + path
 	log.Infof("Setting login path to %s", loginPath)
 
 	g.router.Post(loginPath, g.loginHandler())
+	if g.options.RevocationList == nil {
+		g.options.RevocationList = newDBRevocationList(g.db)
+	}
+	g.router.Post(loginPath+"/logout", g.logoutHandler())
+	if g.options.RefreshTokenTTL > 0 {
+		if g.options.RefreshTokenStore == nil {
+			g.options.RefreshTokenStore = newDBRefreshTokenStore(g.db)
+		}
+		g.router.Post(loginPath+"/refresh", g.refreshHandler())
+	}
+	if len(g.options.SigningKeys) > 0 {
+		g.addJWKSRoute()
+	}
 }
 
 // loginHandler returns the handler for the path set in SetAuth. The handler
 // expects to receive a json map which it will deserialise to map[string]interface{}
-// and pass on to LoginModel.CheckLoginDetails
-func (g *Grapi) loginHandler() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+// and pass on to LoginModel.CheckLoginDetails (or SubjectLoginModel.CheckLogin). On success it returns
+//   {"token":"...","expires_in":3600,"refresh_token":"..."}
+// where refresh_token is only given if Options.RefreshTokenTTL is set.
+func (g *Grapi) loginHandler() web.HandlerType {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
 		body := httpBody(r)
-		var f interface{}
-		if err := json.Unmarshal(body, &f); err != nil {
-			http.Error(w, `{"error":"Malformed JSON"}`, 422)
+		var m map[string]interface{}
+		if err := json.Unmarshal(body, &m); err != nil {
+			g.writeProblem(c, w, r, NewProblem(422, "Malformed JSON"))
 			log.Error("Receieved malformed JSON body")
 			return
 		}
 
-		m := f.(map[string]interface{})
-		user_id, err := g.options.LoginModel.CheckLoginDetails(&m, g)
+		sub, err := g.subjectModel().CheckLogin(&m, g)
 		if err != nil {
-			http.Error(w, `{"error":"Login failed"}`, 403)
+			g.writeProblem(c, w, r, NewProblem(403, "Login failed"))
 			log.Errorf("Login Failed %v", err)
 			return
 		}
-		log.Infof("Logged in user %v", user_id)
-		token := getJWTToken(user_id, g.options.JwtKey)
-		w.Write([]byte(`{"token":"` + token + `"}`))
+		log.Infof("Logged in user %v", sub)
+		g.writeTokens(c, w, r, sub, "")
 	}
 }
 
+// parseToken finds the jwt token in the http headers (or ?access_token=) and
+// checks its signature (see verificationKey).
+func (g *Grapi) parseToken(r *http.Request) (*jwt.Token, error) {
+	return jwt.ParseFromRequest(r, g.verificationKey)
+}
+
 // defaultAuthenticator returns an Authenticator that looks for a jwt token in the http headers, authenticates it,
-// and uses it to grab a LoginModel by id which it then stores in the request.
+// and uses its subject to grab the user (see loginObject) which it then stores in the request along with the
+// claims. Tokens in Options.RevocationList are refused.
 func (g *Grapi) defaultAuthenticator() Authenticator {
 	return func(req ReqToAuthenticate) bool {
-		r := req.GetRequest()
-		w := req.GetResponseWriter()
-		token, tokerr := jwt.ParseFromRequest(r, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				log.WithFields(log.Fields{"method": token.Header["alg"]}).Warn("JWT Auth: Unexpected signing method.")
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(req.Options().JwtKey), nil
-		})
-		if token != nil && token.Valid {
-			guser, err := req.Options().LoginModel.GetById(uint(token.Claims["id"].(float64)), g)
-			if err != nil {
-				w.WriteHeader(401)
-				fmt.Fprintf(w, "Unauthorized")
-				log.WithFields(log.Fields{"id": token.Claims["id"]}).Warn("Cannot find logged in user")
-				return false
-			}
-			user := guser.(LoginModel)
-			req.SetLoginObject(user)
-			return true
-		} else {
+		token, tokerr := g.parseToken(req.GetRequest())
+		if token == nil || !token.Valid {
 			log.WithFields(log.Fields{"error": tokerr}).Warn("Auth: JWT token did not validate")
-			http.Error(w, "Unauthorized", 401)
+			return req.Fail(NewProblem(401, "Invalid or missing token"))
+		}
+		revoked, err := g.tokenRevoked(token)
+		if err != nil {
+			return req.Fail(err)
+		}
+		if revoked {
+			log.WithFields(log.Fields{"sub": tokenSubject(token), "jti": token.Claims["jti"]}).Warn("Auth: JWT token has been revoked")
+			return req.Fail(NewProblem(401, "Token has been revoked"))
 		}
-		return false
+		sub := tokenSubject(token)
+		if sub == "" {
+			log.Warn("Auth: JWT token has no subject")
+			return req.Fail(NewProblem(401, "Invalid or missing token"))
+		}
+		req.SetClaims(token.Claims)
+		user, err := g.loginObject(sub, token.Claims)
+		if err != nil {
+			log.WithFields(log.Fields{"sub": sub}).Warn("Cannot find logged in user")
+			return req.Fail(NewProblem(401, "Unknown user"))
+		}
+		req.SetLoginObject(user)
+		return true
 	}
 }
 
-//Create a JWT token with id=id and expiring in 1 hour
-func getJWTToken(id uint, key string) string {
-	token := jwt.New(jwt.SigningMethodHS256)
+//Create a JWT token with subject sub and any extra claims, expiring after ttl, signed with key. It has a unique
+//jti so that it can be revoked, and iat_us (the time it was issued in microseconds) so that logging in again
+//straight after RevokeAllSessions gives a token that isn't revoked. An error is returned if it can't be signed.
+func getJWTToken(sub string, claims map[string]interface{}, key SigningKey, ttl time.Duration) (string, error) {
+	token := jwt.New(key.Method)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	for k, v := range claims {
+		if registeredClaims[k] {
+			log.WithFields(log.Fields{"claim": k}).Warn("Can't override registered claim")
+			continue
+		}
+		token.Claims[k] = v
+	}
 	// Set some claims
-	token.Claims["id"] = id
-	token.Claims["exp"] = time.Now().Add(time.Hour * 1).Unix()
-	log.WithFields(log.Fields{"expiry": token.Claims["exp"], "id": id}).Info("Signing token.")
+	token.Claims["sub"] = sub
+	token.Claims["jti"] = randomToken()
+	now := time.Now()
+	token.Claims["iat"] = now.Unix()
+	token.Claims["iat_us"] = now.UnixNano() / int64(time.Microsecond)
+	token.Claims["exp"] = now.Add(ttl).Unix()
+	log.WithFields(log.Fields{"expiry": token.Claims["exp"], "sub": sub, "kid": key.ID}).Info("Signing token.")
 	// Sign and get the complete encoded token as a string
-	tokenString, err := token.SignedString([]byte(key))
-	log.Printf("Token: %s, error %v", tokenString, err)
-	return tokenString
+	tokenString, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "jti": token.Claims["jti"], "kid": key.ID}).Error("Can't sign token")
+	}
+	return tokenString, err
 }
@@ -0,0 +1,324 @@
+package grapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+)
+
+// defaultMaxBatchSize is the most items a bulk request may have if
+// RouteOptions.MaxBatchSize isn't set.
+const defaultMaxBatchSize = 100
+
+// bulkItem returns a copy of r for one item of a bulk request, with id as its
+// :id param if given. Problems written by the item's steps are recorded in its
+// failure rather than sent to the client, so that every item can be reported.
+func (r *request) bulkItem(id string) *request {
+	item := *r
+	item.items = nil
+	item.inBatch = true
+	if id != "" {
+		params := make(map[string]string)
+		for k, v := range r.C.URLParams {
+			params[k] = v
+		}
+		params["id"] = id
+		item.C.URLParams = params
+	}
+	r.items = append(r.items, &item)
+	return &item
+}
+
+// checkBatchSize returns an error if a bulk request has no items, or more than
+// RouteOptions.MaxBatchSize.
+func (r *request) checkBatchSize(n int) bool {
+	max := r.options.MaxBatchSize
+	if max == 0 {
+		max = defaultMaxBatchSize
+	}
+	if n == 0 {
+		return r.problem(422, "No items given")
+	}
+	if n > max {
+		log.WithFields(log.Fields{"items": n, "max": max}).Warn("Batch too large")
+		return r.problem(http.StatusRequestEntityTooLarge, fmt.Sprintf("At most %d items can be sent at once", max))
+	}
+	return true
+}
+
+// parseBulkBody unmarshals the uploaded json array into one raw message per item.
+func (r *request) parseBulkBody() ([]json.RawMessage, bool) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(httpBody(r.R), &raws); err != nil {
+		log.WithFields(log.Fields{"error": err}).Warn("Can't parse incoming json")
+		return nil, r.problem(422, "Can't parse json: "+err.Error())
+	}
+	return raws, r.checkBatchSize(len(raws))
+}
+
+// eachItem calls step for every item of a bulk request that hasn't yet failed.
+// It returns false if a step stopped without an error to report, in which case
+// it has written its own response.
+func (r *request) eachItem(step func(item *request) bool) bool {
+	for _, item := range r.items {
+		if item.failure == nil && !step(item) && item.failure == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// checkItems returns a Problem listing the errors of every failed item of a bulk
+// request, keyed by the item's position in the request. Its status is that of
+// the first failed item.
+func (r *request) checkItems() bool {
+	var p *Problem
+	for i, item := range r.items {
+		if item.failure == nil {
+			continue
+		}
+		ip, ok := item.failure.(*Problem)
+		if !ok {
+			log.WithFields(log.Fields{"error": item.failure, "item": i}).Warn("Bulk item failed")
+			ip = NewProblem(500, "")
+		}
+		if p == nil {
+			p = NewProblem(ip.Status, "")
+			p.Items = make(map[int]*Problem)
+		}
+		p.Items[i] = ip
+	}
+	if p == nil {
+		return true
+	}
+	p.Detail = fmt.Sprintf("%d of %d items failed", len(p.Items), len(r.items))
+	return r.writeProblem(p)
+}
+
+// writeItems calls write for each item of a bulk request, stopping at the first
+// failure as the transaction is then rolled back anyway.
+func (r *request) writeItems(write func(item *request) bool) bool {
+	for _, item := range r.items {
+		if !write(item) {
+			break
+		}
+	}
+	return r.checkItems()
+}
+
+// collectResults sets r.Result (and for uploads r.Uploaded) to slices of the
+// items' results, in the order they were sent.
+func (r *request) collectResults() bool {
+	sliceType := reflect.SliceOf(r.Type)
+	results := reflect.ValueOf(getReflectedSlicePtr(sliceType)).Elem()
+	uploads := reflect.ValueOf(getReflectedSlicePtr(sliceType)).Elem()
+	for _, item := range r.items {
+		results.Set(reflect.Append(results, reflect.ValueOf(item.Result).Elem()))
+		if item.Uploaded != nil {
+			uploads.Set(reflect.Append(uploads, reflect.ValueOf(item.Uploaded).Elem()))
+		}
+	}
+	r.Result = results.Addr().Interface()
+	if r.method != "DELETE" {
+		r.Uploaded = uploads.Addr().Interface()
+	}
+	return true
+}
+
+// ParseBulkUpload unmarshals the uploaded json array of new items, and validates
+// each of them.
+func (r *request) ParseBulkUpload() bool {
+	raws, ok := r.parseBulkBody()
+	if !ok {
+		return false
+	}
+	for _, raw := range raws {
+		item := r.bulkItem("")
+		uploaded := reflect.New(r.Type).Interface()
+		if err := json.Unmarshal(raw, uploaded); err != nil {
+			item.problem(422, "Can't parse json: "+err.Error())
+			continue
+		}
+		item.Uploaded = uploaded
+		item.validateUpload()
+	}
+	return r.checkItems()
+}
+
+// GetBulkPatchItems loads the item for each element of the uploaded json array,
+// which must have an "id", and patches it with the element as in
+// PatchResultWithUploaded.
+func (r *request) GetBulkPatchItems() bool {
+	raws, ok := r.parseBulkBody()
+	if !ok {
+		return false
+	}
+	for _, raw := range raws {
+		var fields map[string]json.RawMessage
+		json.Unmarshal(raw, &fields)
+		id := strings.Trim(string(fields["id"]), `"`)
+		item := r.bulkItem(id)
+		if id == "" || id == "null" {
+			item.problem(422, "Each item must have an id")
+			continue
+		}
+		if item.GetItemById() {
+			if f := r.options.versionField(r.columns); f != nil {
+				item.version = itemVersion(item.Result, f)
+			}
+			item.patchResult(raw)
+		}
+	}
+	return r.checkItems()
+}
+
+// GetItemsByIds loads the items listed in ?ids=1,2,3 for a bulk delete.
+func (r *request) GetItemsByIds() bool {
+	ids := strings.Split(r.R.URL.Query().Get("ids"), ",")
+	if len(ids) == 1 && ids[0] == "" {
+		return r.badRequest(fmt.Errorf("ids must list the items to delete"))
+	}
+	if !r.checkBatchSize(len(ids)) {
+		return false
+	}
+	for _, id := range ids {
+		item := r.bulkItem(strings.TrimSpace(id))
+		if item.GetItemById() && r.options.VersionColumn != "" {
+			item.version = itemVersion(item.Result, r.options.versionField(r.columns))
+		}
+	}
+	return r.checkItems()
+}
+
+// CheckBulkUpload calls the CheckUpload callback for each item of a bulk request.
+func (r *request) CheckBulkUpload() bool {
+	if r.options.CheckUpload == nil {
+		return true
+	}
+	return r.eachItem(func(item *request) bool { return r.options.CheckUpload(item) }) && r.checkItems()
+}
+
+// BulkPostDB creates each of the uploaded items.
+func (r *request) BulkPostDB() bool {
+	return r.writeItems((*request).PostDB) && r.collectResults()
+}
+
+// BulkPatchDB saves each of the patched items.
+func (r *request) BulkPatchDB() bool {
+	return r.writeItems((*request).PatchDB) && r.collectResults()
+}
+
+// BulkDeleteFromDB deletes each of the items.
+func (r *request) BulkDeleteFromDB() bool {
+	return r.writeItems((*request).DeleteFromDB) && r.collectResults()
+}
+
+// isJSONArray returns true if body holds a json array rather than an object.
+func isJSONArray(body []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(body), []byte("["))
+}
+
+// bulkPostHandler returns a handler for creating many items at once from a json
+// array. A single json object is passed on to the usual handler. All the items
+// are created in one transaction, so if any fail to validate, are rejected by
+// CheckUpload, or can't be saved then none are created, and the errors of each
+// failed item are returned.
+func (g *Grapi) bulkPostHandler(itemType reflect.Type, o *RouteOptions) web.HandlerType {
+	tableName := pluralCamelNameType(itemType)
+	columns := g.getModelFields(itemType)
+	single := g.postHandler(itemType, o).(func(web.C, http.ResponseWriter, *http.Request))
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		body := httpBody(r)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if !isJSONArray(body) {
+			single(c, w, r)
+			return
+		}
+		req := request{api: g, DB: g.db, method: "POST", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o,
+			columns: columns, bulk: true}
+		defer req.rollbackTx()
+		defer req.saveIdempotentResponse()
+		if true &&
+			(o.Authenticate == nil || o.Authenticate(&req)) &&
+			(o.Authorize == nil || o.Authorize(&req)) &&
+			req.CheckIdempotencyKey() &&
+			req.BeginTx() &&
+			req.ParseBulkUpload() &&
+			req.CheckBulkUpload() &&
+			req.BulkPostDB() &&
+			req.SetCreated() &&
+			(o.EditResult == nil || o.EditResult(&req)) &&
+			req.CommitTx() &&
+			req.SerialiseResult() {
+			log.WithFields(log.Fields{"Model": itemType, "items": len(req.items)}).Info("Successful bulk POST")
+		} else {
+			req.writeFailure()
+		}
+	}
+}
+
+// bulkPatchHandler returns a handler for editing many items at once, from a json
+// array of items with their ids. As with bulkPostHandler it's all or nothing.
+func (g *Grapi) bulkPatchHandler(itemType reflect.Type, o *RouteOptions) web.HandlerType {
+	tableName := pluralCamelNameType(itemType)
+	columns := g.getModelFields(itemType)
+	o.versionField(columns)
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		req := request{api: g, DB: g.db, method: "PATCH", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o,
+			columns: columns, bulk: true}
+		defer req.rollbackTx()
+		defer req.saveIdempotentResponse()
+		if true &&
+			(o.Authenticate == nil || o.Authenticate(&req)) &&
+			(o.Authorize == nil || o.Authorize(&req)) &&
+			req.CheckIdempotencyKey() &&
+			req.BeginTx() &&
+			(o.Query == nil || o.Query(&req)) &&
+			req.GetBulkPatchItems() &&
+			req.CheckBulkUpload() &&
+			req.BulkPatchDB() &&
+			(o.EditResult == nil || o.EditResult(&req)) &&
+			req.CommitTx() &&
+			req.SerialiseResult() {
+			log.WithFields(log.Fields{"Model": itemType, "items": len(req.items)}).Info("Successful bulk PATCH")
+		} else {
+			req.writeFailure()
+		}
+	}
+}
+
+// bulkDeleteHandler returns a handler for deleting the items listed in ?ids=1,2,3.
+// As with bulkPostHandler it's all or nothing.
+func (g *Grapi) bulkDeleteHandler(itemType reflect.Type, o *RouteOptions) web.HandlerType {
+	tableName := pluralCamelNameType(itemType)
+	columns := g.getModelFields(itemType)
+	o.versionField(columns)
+	deletedAt := g.deletedAtColumn(itemType)
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		req := request{api: g, DB: g.db, method: "DELETE", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o,
+			columns: columns, deletedAt: deletedAt, bulk: true}
+		defer req.rollbackTx()
+		if true &&
+			(o.Authenticate == nil || o.Authenticate(&req)) &&
+			(o.Authorize == nil || o.Authorize(&req)) &&
+			req.BeginTx() &&
+			(o.Query == nil || o.Query(&req)) &&
+			req.CheckHardDelete() &&
+			req.GetItemsByIds() &&
+			req.BulkDeleteFromDB() &&
+			(o.EditResult == nil || o.EditResult(&req)) &&
+			req.CommitTx() &&
+			req.SerialiseResult() {
+			log.WithFields(log.Fields{"Model": itemType, "items": len(req.items)}).Info("Successful bulk DELETE")
+		} else {
+			req.writeFailure()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestBulk(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	api.AddDefaultRoutes(&Widget{}, RouteOptions{
+		UriModelName: "bulk_widgets",
+		Bulk:         true,
+		MaxBatchSize: 3,
+		CheckUpload: func(req ReqULToCheck) bool {
+			if req.GetUpload().(*Widget).Name == "Forbidden" {
+				return req.Fail(NewError(403, "forbidden", "That name isn't allowed", nil))
+			}
+			return true
+		}})
+	api.AddDefaultRoutes(&VerifiedWidget{}, RouteOptions{UriModelName: "bulk_verified_widgets", Bulk: true})
+
+	count := func(name string) (n int) {
+		db.Model(&Widget{}).Where("name = ?", name).Count(&n)
+		return
+	}
+	problem := func(name string, method string, uri string, body string, code int) Problem {
+		p := Problem{}
+		json.Unmarshal([]byte(testReq(t, name, method, uri, body, code)), &p)
+		return p
+	}
+
+	widgets := []Widget{}
+	body := testReq(t, "Bulk(POST)", "POST", "/api/bulk_widgets", `[{"name":"Bulk A"},{"name":"Bulk B"}]`, 201)
+	json.Unmarshal([]byte(body), &widgets)
+	if len(widgets) != 2 || widgets[0].Name != "Bulk A" || widgets[1].ID == 0 || count("Bulk B") != 1 {
+		t.Errorf("Bulk POST didn't create the items: %s", body)
+	}
+	single := Widget{}
+	json.Unmarshal([]byte(testReq(t, "Bulk(POST single)", "POST", "/api/bulk_widgets", `{"name":"Bulk Single"}`, 201)), &single)
+
+	p := problem("Bulk(POST rejected)", "POST", "/api/bulk_widgets", `[{"name":"Bulk C"},{"name":"Forbidden"},{"name":"Forbidden"}]`, 403)
+	if len(p.Items) != 2 || p.Items[1] == nil || p.Items[1].Code != "forbidden" || p.Items[0] != nil {
+		t.Errorf("Bulk POST should report each failed item: %+v", p)
+	}
+	if count("Bulk C") != 0 {
+		t.Errorf("Bulk POST created items when another failed")
+	}
+	p = problem("Bulk(POST invalid)", "POST", "/api/bulk_verified_widgets",
+		`[{"must_be_hello_world":"Hello World!!"},{"must_be_hello_world":"Goodbye"}]`, 422)
+	if p.Items[1] == nil || len(p.Items[1].Errors) == 0 {
+		t.Errorf("Bulk POST should report validation errors: %+v", p)
+	}
+	testReq(t, "Bulk(POST too many)", "POST", "/api/bulk_widgets", `[{"name":"1"},{"name":"2"},{"name":"3"},{"name":"4"}]`, 413)
+	testReq(t, "Bulk(POST empty)", "POST", "/api/bulk_widgets", `[]`, 422)
+	testReq(t, "Bulk(POST not bulk)", "POST", "/api/widgets", `[{"name":"Bulk D"}]`, 422)
+
+	a, b := widgets[0].ID, widgets[1].ID
+	body = testReq(t, "Bulk(PATCH)", "PATCH", "/api/bulk_widgets",
+		fmt.Sprintf(`[{"id":%d,"name":"Bulk A2"},{"id":%d,"name":"Bulk B2"}]`, a, b), 200)
+	if count("Bulk A2") != 1 || count("Bulk B2") != 1 {
+		t.Errorf("Bulk PATCH didn't edit the items: %s", body)
+	}
+	p = problem("Bulk(PATCH missing)", "PATCH", "/api/bulk_widgets",
+		fmt.Sprintf(`[{"id":%d,"name":"Bulk A3"},{"id":9999,"name":"Bulk X"},{"name":"No ID"}]`, a), 404)
+	if p.Items[1] == nil || p.Items[1].Status != 404 || p.Items[2] == nil || p.Items[2].Status != 422 {
+		t.Errorf("Bulk PATCH should report missing items: %+v", p)
+	}
+	if count("Bulk A3") != 0 {
+		t.Errorf("Bulk PATCH edited items when another failed")
+	}
+
+	testReq(t, "Bulk(DELETE missing)", "DELETE", fmt.Sprintf("/api/bulk_widgets?ids=%d,9999", a), "", 404)
+	if count("Bulk A2") != 1 {
+		t.Errorf("Bulk DELETE deleted items when another failed")
+	}
+	testReq(t, "Bulk(DELETE no ids)", "DELETE", "/api/bulk_widgets", "", 400)
+	testReq(t, "Bulk(DELETE)", "DELETE", fmt.Sprintf("/api/bulk_widgets?ids=%d,%d,%d", a, b, single.ID), "", 200)
+	if count("Bulk A2") != 0 || count("Bulk B2") != 0 || count("Bulk Single") != 0 {
+		t.Errorf("Bulk DELETE didn't delete the items")
+	}
+}
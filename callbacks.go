@@ -20,6 +20,8 @@ type RequestInfo interface {
 	GetRequest() *http.Request
 	GetData() interface{}
 	SetData(d interface{})
+	// Fail stops the request with an error. See NewError.
+	Fail(err error) bool
 }
 
 // For callbacks that have the power to return an error.
@@ -29,6 +31,9 @@ type RequestResponseWriter interface {
 
 type RequestLoginInfo interface {
 	GetLoginObject() interface{}
+	// GetClaims returns the claims of the jwt token the request was authenticated
+	// with by the default authenticator, including any added by a ClaimsLoginModel.
+	GetClaims() map[string]interface{}
 }
 
 // Authenticate - has all the info it wants apart from the LoginObject
@@ -37,6 +42,7 @@ type ReqToAuthenticate interface {
 	RequestInfo
 	RequestResponseWriter
 	SetLoginObject(interface{})
+	SetClaims(map[string]interface{})
 }
 
 // All info, and can decide whether to authorize so can write back.
@@ -53,6 +59,7 @@ type ReqToLimit interface {
 	SetParam(string, string)
 	GetDB() *gorm.DB
 	SetDB(*gorm.DB)
+	GetTx() *gorm.DB
 }
 
 // For checking the final result before it gets serialised
@@ -61,6 +68,7 @@ type ReqULToCheck interface {
 	RequestLoginInfo
 	RequestResponseWriter
 	GetUpload() interface{}
+	GetTx() *gorm.DB
 }
 
 type ReqFinalResult interface {
@@ -69,13 +77,15 @@ type ReqFinalResult interface {
 	GetUpload() interface{}
 	GetResult() interface{}
 	SetResult(interface{})
+	GetTx() *gorm.DB
 }
 
 // Authenticator is called as the first callback in a request. It has
 // access to the http.Request as well as other info via the req object.
 // An Authenticator should return true if the user has successfully
-// authenticated themselves. Otherwise it should return false and
-// use the http.ResponseWriter to explain why to the client.
+// authenticated themselves. Otherwise it should return req.Fail(err) to
+// explain why to the client (or write its own response with the
+// http.ResponseWriter and return false).
 type Authenticator func(req ReqToAuthenticate) bool
 
 // Authorizor is a callback called after Authenticator, and should decide whether
@@ -87,8 +97,7 @@ type Authenticator func(req ReqToAuthenticate) bool
 //   if user.admin || user.id == req.Params("user_id")  {
 //     return true
 //   }
-//   http.Error(req.GetResponseWriter(), "Only admin can access other users widgets", 403)
-//   return false
+//   return req.Fail(grapi.NewError(403, "forbidden", "Only admin can access other users widgets", nil))
 type Authorizor func(req ReqToAuthorize) bool
 
 // QueryLimiter is a callback to edit the gorm database used by the request using
@@ -0,0 +1,57 @@
+package grapi
+
+import (
+	"errors"
+	"testing"
+)
+
+// ClaimsUser is a User that puts its roles in its tokens.
+type ClaimsUser struct {
+	User
+	Roles []string
+}
+
+var claimsUserLoads int
+
+func (_ *ClaimsUser) GetById(id uint, g *Grapi) (LoginModel, error) {
+	claimsUserLoads++
+	return nil, errors.New("GetById shouldn't be needed")
+}
+
+func (_ *ClaimsUser) Claims(id uint, g *Grapi) (map[string]interface{}, error) {
+	return map[string]interface{}{"roles": []string{"editor"}, "tenant": 7, "id": 999}, nil
+}
+
+func (_ *ClaimsUser) FromClaims(id uint, claims map[string]interface{}, g *Grapi) (LoginModel, error) {
+	user := ClaimsUser{User: User{ID: id}}
+	for _, role := range claims["roles"].([]interface{}) {
+		user.Roles = append(user.Roles, role.(string))
+	}
+	return &user, nil
+}
+
+func TestClaims(t *testing.T) {
+	api := New(Options{Db: getTestDb(), UriPrefix: "/claims_api", JwtKey: "RandomString"})
+	api.SetAuth(&ClaimsUser{}, "login")
+	var claims map[string]interface{}
+	var user *ClaimsUser
+	api.AddDefaultRoutes(&Widget{}, RouteOptions{
+		UseDefaultAuth: true,
+		Authorize: func(req ReqToAuthorize) bool {
+			claims = req.GetClaims()
+			user = req.GetLoginObject().(*ClaimsUser)
+			return true
+		}})
+
+	token := getToken(testApiReq(t, api, "Claims(Login)", "POST", "/claims_api/login", `{"name": "admin", "password": "password"}`, 200))
+	testApiReq(t, api, "Claims(GET)", "GET", "/claims_api/widgets?access_token="+token, "", 200)
+	if claims == nil || claims["tenant"] != float64(7) || claims["id"] != float64(1) {
+		t.Errorf("Callbacks should see the token's claims, without registered claims overridden: %v", claims)
+	}
+	if user == nil || user.ID != 1 || len(user.Roles) != 1 || user.Roles[0] != "editor" {
+		t.Errorf("The login object should be built from the claims: %+v", user)
+	}
+	if claimsUserLoads != 0 {
+		t.Errorf("GetById was called %d times", claimsUserLoads)
+	}
+}
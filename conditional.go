@@ -0,0 +1,51 @@
+package grapi
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SetLastModified sets the Last-Modified header from the UpdatedAt field of the
+// item in r.Result. It does nothing if the model has no UpdatedAt time. It must
+// be called before EditResult changes the result. Index routes don't have a
+// Last-Modified, as the latest UpdatedAt of a page doesn't change when an item
+// is deleted from it, so they rely on the ETag.
+func (r *request) SetLastModified() bool {
+	field := reflect.Indirect(reflect.ValueOf(r.Result).Elem().FieldByName("UpdatedAt"))
+	if !field.IsValid() {
+		return true
+	}
+	if t, ok := field.Interface().(time.Time); ok && !t.IsZero() {
+		r.W.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+	return true
+}
+
+// notModified is called by SerialiseResult for GET requests. If no ETag has been
+// set (eg. for an index) it sets one from a hash of the body. It then returns true
+// if the client's If-None-Match or (failing that) If-Modified-Since headers show
+// that it already has this version, so that a 304 can be sent instead.
+func (r *request) notModified(body []byte) bool {
+	etag := r.W.Header().Get("ETag")
+	if etag == "" {
+		etag = hashETag(body)
+		r.W.Header().Set("ETag", etag)
+	}
+	if header := r.R.Header.Get("If-None-Match"); header != "" {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.R.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(r.W.Header().Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
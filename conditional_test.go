@@ -0,0 +1,57 @@
+package grapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// A model with an UpdatedAt time for Last-Modified
+type TimedWidget struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	Name      string    `json:"name"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func TestConditionalGet(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	db.DropTable(&TimedWidget{})
+	db.CreateTable(&TimedWidget{})
+	updated := time.Date(2016, 5, 4, 3, 2, 1, 0, time.UTC)
+	db.Create(&TimedWidget{Name: "Older"})
+	widget := TimedWidget{Name: "Newer"}
+	db.Create(&widget)
+	db.Exec("UPDATE timed_widgets SET updated_at = ? WHERE id = ?", updated, widget.ID)
+	db.Exec("UPDATE timed_widgets SET updated_at = ? WHERE id <> ?", updated.Add(-time.Hour), widget.ID)
+	api.AddDefaultRoutes(&TimedWidget{})
+
+	for _, uri := range []string{"/api/timed_widgets/2", "/api/timed_widgets", "/api/timed_widgets/2?fields=name"} {
+		rec := testReqWithHeaders(t, "Conditional(GET)", "GET", uri, "", nil, 200)
+		etag := rec.Header().Get("ETag")
+		if etag == "" {
+			t.Errorf("No ETag for %s", uri)
+		}
+		if uri == "/api/timed_widgets/2" && rec.Header().Get("Last-Modified") != updated.Format(http.TimeFormat) ||
+			uri == "/api/timed_widgets" && rec.Header().Get("Last-Modified") != "" {
+			t.Errorf("Wrong Last-Modified for %s: %v", uri, rec.Header().Get("Last-Modified"))
+		}
+		rec = testReqWithHeaders(t, "Conditional(If-None-Match)", "GET", uri, "", map[string]string{"If-None-Match": `"other", ` + etag}, 304)
+		if rec.Body.Len() != 0 {
+			t.Errorf("Got a body with 304 for %s: %s", uri, rec.Body.String())
+		}
+		testReqWithHeaders(t, "Conditional(Weak If-None-Match)", "GET", uri, "", map[string]string{"If-None-Match": "W/" + etag}, 304)
+		testReqWithHeaders(t, "Conditional(Changed)", "GET", uri, "", map[string]string{"If-None-Match": `"other"`}, 200)
+	}
+	uri := "/api/timed_widgets/2"
+	testReqWithHeaders(t, "Conditional(If-Modified-Since)", "GET", uri, "", map[string]string{"If-Modified-Since": updated.Format(http.TimeFormat)}, 304)
+	testReqWithHeaders(t, "Conditional(Modified)", "GET", uri, "", map[string]string{"If-Modified-Since": updated.Add(-time.Second).Format(http.TimeFormat)}, 200)
+	testReqWithHeaders(t, "Conditional(If-None-Match wins)", "GET", uri, "",
+		map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": updated.Format(http.TimeFormat)}, 200)
+
+	// Deleting an item doesn't change the latest UpdatedAt, so an index must not 304 on If-Modified-Since
+	etag := testReqWithHeaders(t, "Conditional(Index)", "GET", "/api/timed_widgets", "", nil, 200).Header().Get("ETag")
+	db.Where("id <> ?", widget.ID).Delete(&TimedWidget{})
+	testReqWithHeaders(t, "Conditional(Index If-Modified-Since)", "GET", "/api/timed_widgets", "", map[string]string{"If-Modified-Since": updated.Format(http.TimeFormat)}, 200)
+	testReqWithHeaders(t, "Conditional(Index deleted)", "GET", "/api/timed_widgets", "", map[string]string{"If-None-Match": etag}, 200)
+}
@@ -0,0 +1,179 @@
+package grapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	reservedParams["cursor"] = true
+}
+
+// cursorPosition is the decoded content of a cursor token. It holds the sort
+// value and primary key of the last item on the previous page.
+type cursorPosition struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// encodeCursor serialises and signs a cursor position so that clients can't
+// forge or tamper with it.
+func encodeCursor(pos cursorPosition, key string) string {
+	payload, _ := json.Marshal(pos)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// decodeCursor checks the signature of a cursor token and returns its position.
+func decodeCursor(token string, key string) (*cursorPosition, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Malformed cursor")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed cursor")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed cursor")
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+	pos := cursorPosition{}
+	if err := json.Unmarshal(payload, &pos); err != nil {
+		return nil, fmt.Errorf("Malformed cursor")
+	}
+	return &pos, nil
+}
+
+// cursorValue formats the value of field f in the struct v for use in a cursor.
+func cursorValue(v reflect.Value, f *modelField) string {
+	fv := reflect.Indirect(v.FieldByName(f.Name))
+	if !fv.IsValid() {
+		return ""
+	}
+	if t, ok := fv.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(fv.Interface())
+}
+
+// cursorColumns returns the field used to order a cursor paginated route, whether
+// it is in descending order, and the primary key used to break ties. It panics
+// if these can't be found as it will only be called when the route is set up.
+func (ro *RouteOptions) cursorColumns(columns modelFields) (*modelField, bool, *modelField) {
+	var pk *modelField
+	for _, f := range columns {
+		if f.IsPrimaryKey {
+			pk = f
+		}
+	}
+	if pk == nil {
+		log.Panicf("Cursor pagination needs a primary key that is serialised to json")
+	}
+	name := ro.CursorField
+	desc := strings.HasPrefix(name, "-")
+	name = strings.TrimPrefix(name, "-")
+	if name == "" {
+		return pk, desc, pk
+	}
+	field := columns.lookup(name)
+	if field == nil {
+		log.Panicf("Unknown CursorField %q", ro.CursorField)
+	}
+	if field.nullable() {
+		log.Panicf("CursorField %q can be NULL, which can't be paged through", ro.CursorField)
+	}
+	return field, desc, pk
+}
+
+// cursorPaginate is used by Paginate for routes with RouteOptions.CursorPagination.
+// Instead of an offset it takes an opaque ?cursor= from the previous page, and
+// uses it to select the items that come after the previous page in the order of
+// RouteOptions.CursorField.
+func (r *request) cursorPaginate() bool {
+	q := r.R.URL.Query()
+	if q.Get("page") != "" || q.Get("per_page") != "" || q.Get("offset") != "" {
+		return r.badRequest(fmt.Errorf("This route uses cursor pagination. Use limit and cursor"))
+	}
+	limit, err := r.intParam("limit")
+	if err != nil {
+		return r.badRequest(err)
+	}
+	def, max := r.pageSizes()
+	if limit == 0 {
+		limit = def
+	}
+	if max > 0 && (limit == 0 || limit > max) {
+		limit = max
+	}
+	if limit == 0 {
+		return r.badRequest(fmt.Errorf("limit must be given"))
+	}
+
+	field, desc, pk := r.options.cursorColumns(r.columns)
+	op, dir := ">", "ASC"
+	if desc {
+		op, dir = "<", "DESC"
+	}
+	col := fmt.Sprintf("%s.%s", r.TableName, field.DBName)
+	pkCol := fmt.Sprintf("%s.%s", r.TableName, pk.DBName)
+	if token := q.Get("cursor"); token != "" {
+		pos, err := decodeCursor(token, r.api.options.JwtKey)
+		if err != nil {
+			return r.badRequest(err)
+		}
+		id, err := pk.parseValue(pos.ID)
+		if err != nil {
+			return r.badRequest(fmt.Errorf("Invalid cursor"))
+		}
+		if field == pk {
+			r.DB = r.DB.Where(fmt.Sprintf("%s %s ?", pkCol, op), id)
+		} else {
+			v, err := field.parseValue(pos.Value)
+			if err != nil {
+				return r.badRequest(fmt.Errorf("Invalid cursor"))
+			}
+			r.DB = r.DB.Where(fmt.Sprintf("%s %s ? OR (%s = ? AND %s %s ?)", col, op, col, pkCol, op), v, v, id)
+		}
+	}
+	if field != pk {
+		r.DB = r.DB.Order(col + " " + dir)
+	}
+	// Get one extra item so we know if there is a next page
+	r.DB = r.DB.Order(pkCol + " " + dir).Limit(limit + 1)
+	r.page = &pageInfo{limit: limit, cursor: true}
+	return true
+}
+
+// TrimCursorPage removes the extra item fetched by cursorPaginate and uses the
+// last item on the page to build the cursor for the next page.
+func (r *request) TrimCursorPage() bool {
+	p := r.page
+	if p == nil || !p.cursor {
+		return true
+	}
+	items := reflect.ValueOf(r.Result).Elem()
+	if items.Len() <= p.limit {
+		return true
+	}
+	items.Set(items.Slice(0, p.limit))
+	last := items.Index(p.limit - 1)
+	field, _, pk := r.options.cursorColumns(r.columns)
+	pos := cursorPosition{Value: cursorValue(last, field), ID: cursorValue(last, pk)}
+	p.nextCursor = encodeCursor(pos, r.api.options.JwtKey)
+	return true
+}
@@ -0,0 +1,201 @@
+package grapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Kinds of database error that we can explain to the client.
+const (
+	dbErrUnique     = "unique_violation"
+	dbErrForeignKey = "foreign_key_violation"
+	dbErrNotNull    = "not_null_violation"
+)
+
+// dbErrorInfo describes a database error in a driver independent way.
+type dbErrorInfo struct {
+	kind    string   // One of the dbErr constants, or "" if unknown
+	columns []string // The database columns involved, if known
+	index   string   // The index violated, if the error names it rather than the columns
+}
+
+// classifyDBError works out what kind of constraint violation err is, and which
+// columns it involves. It understands the errors of the sqlite3, MySQL and
+// Postgres drivers. As we don't want to depend on the drivers this is done by
+// the error's message and (using reflection) the fields of the driver's error
+// type.
+func classifyDBError(err error) dbErrorInfo {
+	v := reflect.Indirect(reflect.ValueOf(err))
+	if v.Kind() == reflect.Struct {
+		// github.com/go-sql-driver/mysql.MySQLError
+		if number := v.FieldByName("Number"); number.IsValid() && number.Kind() == reflect.Uint16 {
+			return classifyMySQLError(uint16(number.Uint()), err.Error())
+		}
+		// github.com/lib/pq.Error
+		if code := v.FieldByName("Code"); code.IsValid() && code.Kind() == reflect.String {
+			return classifyPostgresError(code.String(), stringField(v, "Column"), stringField(v, "Detail"))
+		}
+	}
+	return classifySqliteError(err.Error())
+}
+
+// stringField returns the string field name of the struct v, or "".
+func stringField(v reflect.Value, name string) string {
+	f := v.FieldByName(name)
+	if !f.IsValid() || f.Kind() != reflect.String {
+		return ""
+	}
+	return f.String()
+}
+
+// between returns the part of s between the first start and the following end.
+func between(s string, start string, end string) string {
+	i := strings.Index(s, start)
+	if i < 0 {
+		return ""
+	}
+	s = s[i+len(start):]
+	if j := strings.Index(s, end); j >= 0 {
+		return s[:j]
+	}
+	return ""
+}
+
+// splitColumns splits a list of possibly table qualified columns, eg. "widgets.a, b"
+func splitColumns(s string) []string {
+	columns := make([]string, 0)
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if i := strings.LastIndex(c, "."); i >= 0 {
+			c = c[i+1:]
+		}
+		if c != "" {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// classifySqliteError uses the messages of sqlite3, eg. "UNIQUE constraint failed: widgets.name"
+func classifySqliteError(msg string) dbErrorInfo {
+	columns := splitColumns(between(msg+"\n", "constraint failed: ", "\n"))
+	switch {
+	case strings.HasPrefix(msg, "UNIQUE constraint failed"):
+		return dbErrorInfo{kind: dbErrUnique, columns: columns}
+	case strings.HasPrefix(msg, "NOT NULL constraint failed"):
+		return dbErrorInfo{kind: dbErrNotNull, columns: columns}
+	case strings.HasPrefix(msg, "FOREIGN KEY constraint failed"):
+		return dbErrorInfo{kind: dbErrForeignKey, columns: columns}
+	}
+	return dbErrorInfo{}
+}
+
+// classifyMySQLError uses the MySQL error number, and the message to find the column.
+// Duplicate entries only give the name of the index, eg. "uix_widgets_name" (or
+// "widgets.uix_widgets_name"), which dbError maps to its columns.
+func classifyMySQLError(number uint16, msg string) dbErrorInfo {
+	switch number {
+	case 1062: // Duplicate entry 'x' for key 'uix_widgets_name'
+		index := between(msg, "for key '", "'")
+		if i := strings.LastIndex(index, "."); i >= 0 {
+			index = index[i+1:]
+		}
+		return dbErrorInfo{kind: dbErrUnique, index: index}
+	case 1048: // Column 'name' cannot be null
+		return dbErrorInfo{kind: dbErrNotNull, columns: splitColumns(between(msg, "Column '", "'"))}
+	case 1451, 1452: // Cannot delete or update a parent row / add or update a child row: ... FOREIGN KEY (`user_id`) ...
+		return dbErrorInfo{kind: dbErrForeignKey, columns: splitColumns(strings.Replace(between(msg, "FOREIGN KEY (", ")"), "`", "", -1))}
+	}
+	return dbErrorInfo{}
+}
+
+// uniqueIndexColumns returns the columns of the request's model in the unique
+// index called name, as gorm names the indexes made from unique_index tags (or
+// MySQL names those made from unique tags). It returns nil if the model has no
+// such index, eg. if it was made by hand.
+func (r *request) uniqueIndexColumns(name string) []string {
+	t := r.Type
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	scope := r.api.db.NewScope(reflect.New(t).Interface())
+	var columns []string
+	for _, f := range scope.GetModelStruct().StructFields {
+		if _, ok := f.TagSettings["UNIQUE"]; ok && f.DBName == name {
+			columns = append(columns, f.DBName)
+		}
+		indexes, ok := f.TagSettings["UNIQUE_INDEX"]
+		if !ok {
+			continue
+		}
+		for _, index := range strings.Split(indexes, ",") {
+			if index == "UNIQUE_INDEX" || index == "" {
+				index = fmt.Sprintf("uix_%v_%v", scope.TableName(), f.DBName)
+			}
+			if index == name {
+				columns = append(columns, f.DBName)
+			}
+		}
+	}
+	return columns
+}
+
+// classifyPostgresError uses the SQLSTATE code, and the column or detail (eg.
+// "Key (name)=(x) already exists.") to find the column.
+func classifyPostgresError(code string, column string, detail string) dbErrorInfo {
+	columns := splitColumns(column)
+	if len(columns) == 0 {
+		columns = splitColumns(between(detail, "Key (", ")="))
+	}
+	switch code {
+	case "23505":
+		return dbErrorInfo{kind: dbErrUnique, columns: columns}
+	case "23502":
+		return dbErrorInfo{kind: dbErrNotNull, columns: columns}
+	case "23503":
+		return dbErrorInfo{kind: dbErrForeignKey, columns: columns}
+	}
+	return dbErrorInfo{}
+}
+
+// dbError logs a database error from a write, and sends it to the client. Unique
+// violations are a 409 Conflict, as are foreign key violations when deleting
+// (the item is still referred to). Other foreign key violations (referring to an
+// item which doesn't exist) and null values are a 422. The columns involved are
+// listed in the errors of the Problem, if they are known. Anything else is a 500.
+func (r *request) dbError(err error) bool {
+	info := classifyDBError(err)
+	if info.index != "" {
+		info.columns = r.uniqueIndexColumns(info.index)
+	}
+	log.WithFields(log.Fields{"error": err, "kind": info.kind, "columns": info.columns}).Warn("Database error")
+	var p *Problem
+	var msg string
+	switch info.kind {
+	case dbErrUnique:
+		p, msg = NewError(409, info.kind, "An item with that value already exists", nil), "Already exists"
+	case dbErrNotNull:
+		p, msg = NewError(422, info.kind, "A required value is missing", nil), "Can't be null"
+	case dbErrForeignKey:
+		if r.method == "DELETE" {
+			p, msg = NewError(409, info.kind, "The item is referred to by other items", nil), "Is referred to"
+		} else {
+			p, msg = NewError(422, info.kind, "The item refers to an item that doesn't exist", nil), "Doesn't exist"
+		}
+	default:
+		return r.problem(500, "Database error")
+	}
+	if len(info.columns) > 0 {
+		p.Errors = make(map[string]string)
+		for _, c := range info.columns {
+			if f := r.columns.lookup(c); f != nil {
+				c = f.JSONName
+			}
+			p.Errors[c] = msg
+		}
+	}
+	return r.writeProblem(p)
+}
@@ -0,0 +1,111 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// A model with unique and not null constraints
+type ConstrainedWidget struct {
+	ID   uint    `gorm:"primary_key" json:"id"`
+	Name string  `gorm:"unique" json:"name"`
+	Code *string `gorm:"not null" json:"code"`
+}
+
+func TestDBErrors(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	db.DropTable(&ConstrainedWidget{})
+	db.CreateTable(&ConstrainedWidget{})
+	api.AddDefaultRoutes(&ConstrainedWidget{})
+
+	testReq(t, "DBError(POST)", "POST", "/api/constrained_widgets", `{"name":"First","code":"a"}`, 201)
+	body := testReq(t, "DBError(POST)", "POST", "/api/constrained_widgets", `{"name":"Second","code":"b"}`, 201)
+	second := ConstrainedWidget{}
+	json.Unmarshal([]byte(body), &second)
+
+	for _, c := range []struct {
+		method, path, body string
+		code               int
+		column, kind       string
+	}{
+		{"POST", "/api/constrained_widgets", `{"name":"First","code":"c"}`, 409, "name", "unique_violation"},
+		{"POST", "/api/constrained_widgets", `{"name":"Third","code":null}`, 422, "code", "not_null_violation"},
+		{"PATCH", fmt.Sprintf("/api/constrained_widgets/%d", second.ID), `{"name":"First"}`, 409, "name", "unique_violation"},
+		{"PUT", fmt.Sprintf("/api/constrained_widgets/%d", second.ID), `{"name":"Second"}`, 422, "code", "not_null_violation"},
+	} {
+		body := testReq(t, "DBError("+c.method+")", c.method, c.path, c.body, c.code)
+		problem := Problem{}
+		json.Unmarshal([]byte(body), &problem)
+		if problem.Code != c.kind || problem.Errors[c.column] == "" {
+			t.Errorf("%s %s didn't report the %s on %s: %s", c.method, c.path, c.kind, c.column, body)
+		}
+	}
+}
+
+// Stand ins for the error types of the MySQL and Postgres drivers
+type MySQLError struct {
+	Number  uint16
+	Message string
+}
+
+func (e *MySQLError) Error() string { return e.Message }
+
+type PQErrorCode string
+type PQError struct {
+	Code   PQErrorCode
+	Detail string
+	Column string
+}
+
+func (e PQError) Error() string { return "pq: error" }
+
+func TestClassifyDBError(t *testing.T) {
+	for _, c := range []struct {
+		err      error
+		expected dbErrorInfo
+	}{
+		{fmt.Errorf("UNIQUE constraint failed: widgets.a, widgets.b"), dbErrorInfo{dbErrUnique, []string{"a", "b"}, ""}},
+		{fmt.Errorf("FOREIGN KEY constraint failed"), dbErrorInfo{dbErrForeignKey, []string{}, ""}},
+		{fmt.Errorf("disk I/O error"), dbErrorInfo{"", nil, ""}},
+		{&MySQLError{1062, "Duplicate entry 'x' for key 'widgets.uix_widgets_name'"}, dbErrorInfo{dbErrUnique, nil, "uix_widgets_name"}},
+		{&MySQLError{1062, "Duplicate entry 'x' for key 'name'"}, dbErrorInfo{dbErrUnique, nil, "name"}},
+		{&MySQLError{1048, "Column 'code' cannot be null"}, dbErrorInfo{dbErrNotNull, []string{"code"}, ""}},
+		{&MySQLError{1452, "Cannot add or update a child row: a foreign key constraint fails (`db`.`widgets`, CONSTRAINT `fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"},
+			dbErrorInfo{dbErrForeignKey, []string{"user_id"}, ""}},
+		{PQError{Code: "23505", Detail: "Key (name)=(x) already exists."}, dbErrorInfo{dbErrUnique, []string{"name"}, ""}},
+		{PQError{Code: "23502", Column: "code"}, dbErrorInfo{dbErrNotNull, []string{"code"}, ""}},
+		{PQError{Code: "23503", Detail: "Key (user_id)=(9) is not present in table \"users\"."}, dbErrorInfo{dbErrForeignKey, []string{"user_id"}, ""}},
+		{PQError{Code: "42P01"}, dbErrorInfo{"", nil, ""}},
+	} {
+		if info := classifyDBError(c.err); !reflect.DeepEqual(info, c.expected) {
+			t.Errorf("Classified %q as %v, expected %v", c.err, info, c.expected)
+		}
+	}
+}
+
+// A model with unique indexes, as MySQL reports them by name
+type IndexedWidget struct {
+	ID     uint   `gorm:"primary_key" json:"id"`
+	Serial string `sql:"unique_index" json:"serial"`
+	Maker  string `sql:"unique_index:uix_maker_model" json:"maker"`
+	Model  string `sql:"unique_index:uix_maker_model" json:"model"`
+	Name   string `gorm:"unique" json:"name"`
+}
+
+func TestMySQLDuplicateKey(t *testing.T) {
+	r := request{api: getTestApi(), Type: reflect.TypeOf(IndexedWidget{})}
+	for key, expected := range map[string][]string{
+		"uix_indexed_widgets_serial":      {"serial"},
+		"indexed_widgets.uix_maker_model": {"maker", "model"},
+		"name":                            {"name"},
+		"indexed_widgets.serial_UNIQUE":   nil,
+	} {
+		info := classifyDBError(&MySQLError{1062, "Duplicate entry 'x' for key '" + key + "'"})
+		if columns := r.uniqueIndexColumns(info.index); info.kind != dbErrUnique || !reflect.DeepEqual(columns, expected) {
+			t.Errorf("Duplicate entry for key %q should be a unique violation on %v: %v %v", key, expected, info, columns)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package grapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// versionField returns the field named by RouteOptions.VersionColumn, or nil if
+// there isn't one. It panics if the field doesn't exist or isn't an integer, so
+// it should be called once when the route is set up.
+func (ro *RouteOptions) versionField(columns modelFields) *modelField {
+	if ro.VersionColumn == "" {
+		return nil
+	}
+	f := columns.lookup(ro.VersionColumn)
+	if f == nil {
+		log.Panicf("Unknown VersionColumn %q", ro.VersionColumn)
+	}
+	switch f.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return f
+	}
+	log.Panicf("VersionColumn %q must be an integer", ro.VersionColumn)
+	return nil
+}
+
+// itemVersion returns the value of the version field of item.
+func itemVersion(item interface{}, f *modelField) int64 {
+	return reflect.ValueOf(item).Elem().FieldByName(f.Name).Convert(reflect.TypeOf(int64(0))).Int()
+}
+
+// etag returns the ETag of item. This is its version if the route has a
+// VersionColumn, and otherwise a hash of its columns.
+func (r *request) etag(item interface{}) string {
+	if f := r.options.versionField(r.columns); f != nil {
+		return fmt.Sprintf(`"%d"`, itemVersion(item, f))
+	}
+	v := reflect.ValueOf(item).Elem()
+	values := make(map[string]interface{})
+	for name, f := range r.columns {
+		values[name] = v.FieldByName(f.Name).Interface()
+	}
+	j, _ := json.Marshal(values)
+	return hashETag(j)
+}
+
+// hashETag returns an ETag made from a hash of j.
+func hashETag(j []byte) string {
+	sum := sha256.Sum256(j)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// SetETag sets the ETag header from the item in r.Result. It must be called before
+// EditResult changes the result. Without a VersionColumn the ETag is a hash of
+// the whole item, so it isn't set if ?fields= has only loaded some of it (and
+// SerialiseResult will instead use a hash of the body).
+func (r *request) SetETag() bool {
+	if r.fields != nil && r.options.VersionColumn == "" {
+		return true
+	}
+	r.W.Header().Set("ETag", r.etag(r.Result))
+	return true
+}
+
+// CheckIfMatch returns 412 Precondition Failed if the request has an If-Match
+// header which doesn't match the ETag of the item in r.Result (as loaded by
+// GetItemById). It also records the item's version so that the write can check
+// that nobody else has changed it since.
+func (r *request) CheckIfMatch() bool {
+	f := r.options.versionField(r.columns)
+	if f != nil && !r.creating {
+		r.version = itemVersion(r.Result, f)
+	}
+	header := r.R.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	if !r.creating {
+		current := r.etag(r.Result)
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || tag == current {
+				return true
+			}
+		}
+	}
+	return r.preconditionFailed()
+}
+
+// preconditionFailed logs and writes a 412 error.
+func (r *request) preconditionFailed() bool {
+	log.WithFields(log.Fields{"If-Match": r.R.Header.Get("If-Match"), "id": r.Param("id")}).Info("Precondition failed")
+	return r.problem(http.StatusPreconditionFailed, "The item has been changed")
+}
+
+// versionedUpdate saves every column of r.Uploaded, incrementing its version,
+// in a single UPDATE which only matches the row if its version hasn't changed
+// since CheckIfMatch. If it has changed then a 412 is returned.
+func (r *request) versionedUpdate() bool {
+	f := r.options.versionField(r.columns)
+	item := reflect.ValueOf(r.Uploaded).Elem()
+	version := item.FieldByName(f.Name)
+	version.Set(reflect.ValueOf(r.version + 1).Convert(version.Type()))
+	values := make(map[string]interface{})
+	for _, field := range r.writeDB().NewScope(r.Uploaded).Fields() {
+		if field.IsNormal && !field.IsIgnored && !field.IsPrimaryKey {
+			values[field.DBName] = field.Field.Interface()
+		}
+	}
+	qstring := fmt.Sprintf("%s.%s = ?", r.TableName, f.DBName)
+	update := r.writeDB().Model(r.Uploaded).Where(qstring, r.version).Updates(values)
+	if update.Error != nil {
+		return r.dbError(update.Error)
+	}
+	if update.RowsAffected == 0 {
+		return r.preconditionFailed()
+	}
+	r.Result = r.Uploaded
+	return true
+}
+
+// versionedDelete deletes r.Result if its version hasn't changed since
+// CheckIfMatch, and otherwise returns a 412.
+func (r *request) versionedDelete() bool {
+	f := r.options.versionField(r.columns)
+	qstring := fmt.Sprintf("%s.%s = ?", r.TableName, f.DBName)
+	del := r.deleteDB().Where(qstring, r.version).Delete(r.Result)
+	if del.Error != nil {
+		return r.dbError(del.Error)
+	}
+	if del.RowsAffected == 0 {
+		return r.preconditionFailed()
+	}
+	return true
+}
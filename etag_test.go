@@ -0,0 +1,73 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// A model with a version column for optimistic concurrency
+type VersionedWidget struct {
+	ID      uint   `gorm:"primary_key" json:"id"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+func TestVersionedETags(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	db.DropTable(&VersionedWidget{})
+	db.CreateTable(&VersionedWidget{})
+	widget := VersionedWidget{Name: "Versioned", Version: 1}
+	db.Create(&widget)
+	api.AddDefaultRoutes(&VersionedWidget{}, RouteOptions{VersionColumn: "version"})
+	uri := fmt.Sprintf("/api/versioned_widgets/%d", widget.ID)
+
+	rec := testReqWithHeaders(t, "Versioned(GET)", "GET", uri, "", nil, 200)
+	if rec.Header().Get("ETag") != `"1"` {
+		t.Errorf("Expected ETag \"1\", got %q", rec.Header().Get("ETag"))
+	}
+	testReqWithHeaders(t, "Versioned(Stale PATCH)", "PATCH", uri, `{"name":"Stale"}`, map[string]string{"If-Match": `"0"`}, 412)
+	rec = testReqWithHeaders(t, "Versioned(PATCH)", "PATCH", uri, `{"name":"Edited","version":99}`, map[string]string{"If-Match": `"0", "1"`}, 200)
+	result := VersionedWidget{}
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.Version != 2 || result.Name != "Edited" || rec.Header().Get("ETag") != `"2"` {
+		t.Errorf("PATCH didn't increment the version: %s %v", rec.Body.String(), rec.Header())
+	}
+	testReqWithHeaders(t, "Versioned(Stale PUT)", "PUT", uri, `{"name":"Stale"}`, map[string]string{"If-Match": `"1"`}, 412)
+	testReqWithHeaders(t, "Versioned(PUT)", "PUT", uri, `{"name":"Replaced"}`, map[string]string{"If-Match": `"2"`}, 200)
+	testReqWithHeaders(t, "Versioned(PATCH without If-Match)", "PATCH", uri, `{"name":"Again"}`, nil, 200)
+	testReqWithHeaders(t, "Versioned(Stale DELETE)", "DELETE", uri, "", map[string]string{"If-Match": `"2"`}, 412)
+
+	// Simulate another writer changing the row between our read and write
+	rec = httptest.NewRecorder()
+	r := request{api: api, W: rec, R: httptest.NewRequest("PATCH", uri, nil), TableName: "versioned_widgets",
+		options: &RouteOptions{VersionColumn: "version"}, columns: api.getModelFields(reflect.TypeOf(widget)),
+		version: 1, Uploaded: &VersionedWidget{ID: widget.ID, Name: "Racer"}}
+	if r.versionedUpdate() || rec.Code != 412 {
+		t.Errorf("Update succeeded although the version had changed")
+	}
+	testReqWithHeaders(t, "Versioned(DELETE)", "DELETE", uri, "", map[string]string{"If-Match": "*"}, 200)
+}
+
+func TestHashETags(t *testing.T) {
+	widget := Widget{Name: "Hashed"}
+	getTestApi().DB().Create(&widget)
+	uri := fmt.Sprintf("/api/widgets/%d", widget.ID)
+	etag := testReqWithHeaders(t, "Hashed(GET)", "GET", uri, "", nil, 200).Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("No ETag on item GET")
+	}
+	if testReqWithHeaders(t, "Hashed(GET fields)", "GET", uri+"?fields=name", "", nil, 200).Header().Get("ETag") == etag {
+		t.Errorf("Got the item's ETag for a partial item")
+	}
+	testReqWithHeaders(t, "Hashed(Stale PATCH)", "PATCH", uri, `{"name":"Stale"}`, map[string]string{"If-Match": `"abc"`}, 412)
+	rec := testReqWithHeaders(t, "Hashed(PATCH)", "PATCH", uri, `{"name":"Changed"}`, map[string]string{"If-Match": etag}, 200)
+	if rec.Header().Get("ETag") == etag {
+		t.Errorf("ETag didn't change when the item did")
+	}
+	testReqWithHeaders(t, "Hashed(Stale DELETE)", "DELETE", uri, "", map[string]string{"If-Match": etag}, 412)
+	testReqWithHeaders(t, "Hashed(DELETE)", "DELETE", uri, "", map[string]string{"If-Match": rec.Header().Get("ETag")}, 200)
+}
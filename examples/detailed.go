@@ -18,6 +18,8 @@ type User struct {
 	Name     string `json:"name"`
 	Password string `json:"-"` // `json:"-"` prevents password ever being serialized to json
 	Admin    bool   `json:"admin"`
+
+	PrivateWidgets []PrivateWidget `json:"private_widgets,omitempty"`
 }
 
 // Check the login details.
@@ -105,8 +107,7 @@ func main() {
 		Authorize: func(req grapi.ReqToAuthorize) bool {
 			user := req.GetLoginObject().(*User)
 			if !user.Admin {
-				http.Error(req.GetResponseWriter(), `{"error":"You need to be admin to do that"}`, 403)
-				return false
+				return req.Fail(grapi.NewError(403, "admin_only", "You need to be admin to do that", nil))
 			}
 			return true
 		}}
@@ -130,8 +131,7 @@ func main() {
 			uploaded := req.GetUpload().(BelongsToUser)
 			// For PATCH and POST routes we also need to check that the uploaded object has the correct user_id
 			if !user.Admin && user.ID != uploaded.UserId() {
-				http.Error(req.GetResponseWriter(), `{"error":"Only admin can change a user_id"}`, 403)
-				return false
+				return req.Fail(grapi.NewError(403, "admin_only", "Only admin can change a user_id", nil))
 			}
 			return true
 		}}
@@ -145,15 +145,10 @@ func main() {
 	// We want people to only see their own widgets, unless they are admin.
 	a.AddDefaultRoutes(&PrivateWidget{}, onlyOwnUnlessAdmin)
 
-	// We are going to make the widget list available to view by user at
-	// /api/user/:user_id/private_widgets
-	a.AddIndexRoute(&PrivateWidget{},
-		&grapi.RouteOptions{
-			Prefix: "/user/:user_id",
-			Query: func(req grapi.ReqToLimit) bool {
-				req.SetDB(req.GetDB().Where("user_id = ?", req.Param("user_id")))
-				return true
-			}})
+	// We are also going to make each user's widgets available at
+	// /api/users/:user_id/private_widgets . These routes are scoped to the user
+	// in the url, and POSTed widgets are given that user_id.
+	a.AddNestedRoutes(&User{}, &PrivateWidget{}, &onlyAuthenticated, onlyOwnUnlessAdmin)
 
 	// Run the server.
 	listener, err := net.Listen("tcp", "127.0.0.1:3000")
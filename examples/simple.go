@@ -27,7 +27,7 @@ func main() {
 	api := grapi.New(grapi.Options{Db: db.Debug()})
 	http.Handle("/api/", api)
 
-	// Add index, get, post, patch and delete routes for widget
+	// Add index, get, post, put, patch and delete routes for widget
 	api.AddDefaultRoutes(&Widget{})
 
 	// Start Server
@@ -0,0 +1,190 @@
+package grapi
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	reservedParams["fields"] = true
+}
+
+// modelField describes a single database column of a model, as seen by API
+// clients in JSON bodies and query parameters.
+type modelField struct {
+	Name         string // The Go struct field name
+	JSONName     string // The name used when the model is serialised to json
+	DBName       string // The database column name
+	Type         reflect.Type
+	IsPrimaryKey bool
+	IsForeignKey bool
+}
+
+// modelFields maps the JSON name of each column of a model to its description.
+type modelFields map[string]*modelField
+
+// getModelFields uses gorm's model metadata to build the modelFields for the
+// model type t (or the element type if t is a slice). Fields that are ignored
+// by gorm, are not plain columns (eg. associations), or are hidden from json
+// with `json:"-"` are left out.
+func (g *Grapi) getModelFields(t reflect.Type) modelFields {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	mf := make(modelFields)
+	scope := g.db.NewScope(reflect.New(t).Interface())
+	for _, sf := range scope.GetModelStruct().StructFields {
+		if sf.IsIgnored || !sf.IsNormal {
+			continue
+		}
+		jsonName := jsonFieldName(sf.Struct)
+		if jsonName == "-" {
+			continue
+		}
+		mf[jsonName] = &modelField{
+			Name:         sf.Name,
+			JSONName:     jsonName,
+			DBName:       sf.DBName,
+			Type:         sf.Struct.Type,
+			IsPrimaryKey: sf.IsPrimaryKey,
+			IsForeignKey: sf.IsForeignKey,
+		}
+	}
+	return mf
+}
+
+// lookup finds a field by its JSON name or, failing that, its database column name.
+// Returns nil if there is no such field.
+func (mf modelFields) lookup(name string) *modelField {
+	if f, ok := mf[name]; ok {
+		return f
+	}
+	for _, f := range mf {
+		if f.DBName == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// jsonFieldName returns the name encoding/json will use for the struct field
+// sf, or "-" if it will not be serialised.
+func jsonFieldName(sf reflect.StructField) string {
+	tag := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if tag == "" {
+		return sf.Name
+	}
+	return tag
+}
+
+// parseValue converts the string s (typically from a query parameter) into a
+// value of the same kind as the field, so that it can be passed to the
+// database as a query argument.
+func (f *modelField) parseValue(s string) (interface{}, error) {
+	t := f.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return time.Parse(time.RFC3339, s)
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(s, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(s, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(s, 64)
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	case reflect.String:
+		return s, nil
+	}
+	return nil, fmt.Errorf("Can't convert %q to %v", s, f.Type)
+}
+
+// SelectFields narrows the database query to the fields named in the ?fields=
+// query parameter (eg. ?fields=id,name) and records them so that StripFields
+// can remove everything else from the output. The primary key (and any cursor
+// field) is always retrieved, as it is needed to fetch associations and pages.
+// Associations included with ?include= may also be named, and foreign keys are
+// retrieved if there are any.
+func (r *request) SelectFields() bool {
+	param := r.R.URL.Query().Get("fields")
+	if param == "" {
+		return true
+	}
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(param, ",") {
+		if r.included[name] {
+			continue
+		}
+		field := r.columns.lookup(name)
+		if field == nil {
+			return r.badRequest(fmt.Errorf("Unknown field %q", name))
+		}
+		selected[field.JSONName] = true
+	}
+	r.fields = selected
+	var cursorField *modelField
+	if r.options.CursorPagination {
+		cursorField, _, _ = r.options.cursorColumns(r.columns)
+	}
+	columns := make([]string, 0)
+	for _, f := range r.columns {
+		if selected[f.JSONName] || f.IsPrimaryKey || f == cursorField || (f.IsForeignKey && r.included != nil) {
+			columns = append(columns, fmt.Sprintf("%s.%s", r.TableName, f.DBName))
+		}
+	}
+	sort.Strings(columns)
+	r.DB = r.DB.Select(strings.Join(columns, ", "))
+	return true
+}
+
+// StripFields removes any fields that weren't asked for with ?fields= from the
+// result. It leaves the result alone if EditResult has replaced it with
+// something other than the model (or slice of models) for the route.
+func (r *request) StripFields() bool {
+	if r.fields == nil || reflect.TypeOf(r.Result) != reflect.PtrTo(r.Type) {
+		return true
+	}
+	j, err := json.Marshal(r.Result)
+	if err != nil {
+		return true // Let SerialiseResult report the problem
+	}
+	if r.Type.Kind() == reflect.Slice {
+		items := make([]map[string]interface{}, 0)
+		json.Unmarshal(j, &items)
+		for _, item := range items {
+			r.stripItem(item)
+		}
+		r.Result = items
+	} else {
+		item := make(map[string]interface{})
+		json.Unmarshal(j, &item)
+		r.stripItem(item)
+		r.Result = item
+	}
+	return true
+}
+
+// stripItem removes unselected fields from a single serialised item.
+func (r *request) stripItem(item map[string]interface{}) {
+	for k := range item {
+		if !r.fields[k] && !r.included[k] {
+			delete(item, k)
+		}
+	}
+}
+
+// nullable returns true if the field can hold NULL, ie. it is a pointer or an
+// sql.Null type (or anything else that is a driver.Valuer).
+func (f *modelField) nullable() bool {
+	return f.Type.Kind() == reflect.Ptr || f.Type.Implements(reflect.TypeOf((*driver.Valuer)(nil)).Elem())
+}
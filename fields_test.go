@@ -0,0 +1,42 @@
+package grapi
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Check ?fields= narrows the output of item and index routes.
+func TestSelectFields(t *testing.T) {
+	getTestApi().AddIndexRoute(&Widget{}, &RouteOptions{UriModelName: "cursor_fields_widgets", CursorPagination: true, CursorField: "name"})
+	body := testReq(t, "Fields(item)", "GET", "/api/widgets/2?fields=name", "", 200)
+	if body != `{"name":"Widget 2"}` {
+		t.Errorf("Didn't get only the name of widget 2: %s", body)
+	}
+	body = testReq(t, "Fields(index)", "GET", "/api/widgets?id[lte]=2&fields=id", "", 200)
+	if body != `[{"id":1},{"id":2}]` {
+		t.Errorf("Didn't get only the ids of widgets: %s", body)
+	}
+	body = testReq(t, "Fields(paginated)", "GET", "/api/cursor_fields_widgets?limit=1&fields=name", "", 200)
+	if body != `[{"name":"Widget 1"}]` {
+		t.Errorf("Didn't get only the name of the first widget: %s", body)
+	}
+	testReq(t, "Fields(unknown)", "GET", "/api/widgets/2?fields=colour", "", 400)
+	testReq(t, "Fields(association)", "GET", "/api/users/1?fields=private_widgets", "", 400)
+}
+
+// Fields hidden with `json:"-"` can't be selected.
+type HiddenFieldWidget struct {
+	ID     uint   `gorm:"primary_key" json:"id"`
+	Name   string `json:"name"`
+	Secret string `json:"-"`
+}
+
+func TestGetModelFields(t *testing.T) {
+	fields := getTestApi().getModelFields(reflect.TypeOf(HiddenFieldWidget{}))
+	if len(fields) != 2 || fields["id"] == nil || !fields["id"].IsPrimaryKey || fields["name"].DBName != "name" {
+		t.Errorf("Didn't get the right model fields: %v", fields)
+	}
+	if fields.lookup("secret") != nil || fields.lookup("Secret") != nil {
+		t.Errorf("Found a hidden field")
+	}
+}
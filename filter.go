@@ -0,0 +1,89 @@
+package grapi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// filterOperators maps the operators that can be used in a filter query
+// parameter (eg. ?age[gt]=30) to their SQL equivalent.
+var filterOperators = map[string]string{
+	"eq":   "=",
+	"ne":   "<>",
+	"gt":   ">",
+	"gte":  ">=",
+	"lt":   "<",
+	"lte":  "<=",
+	"like": "LIKE",
+	"in":   "IN",
+}
+
+// reservedParams are query parameters which have their own meaning to grapi,
+// and so are never treated as filters.
+var reservedParams = map[string]bool{
+	"access_token": true,
+}
+
+// parseFilterKey splits a query parameter name of the form "field[op]" into
+// field and operator. A plain "field" has the operator "eq".
+func parseFilterKey(key string) (string, string) {
+	i := strings.Index(key, "[")
+	if i < 0 || !strings.HasSuffix(key, "]") {
+		return key, "eq"
+	}
+	return key[:i], key[i+1 : len(key)-1]
+}
+
+// FilterItems scopes r.DB using the query parameters of the request. Each
+// parameter is matched against the model's json or database field names, so
+// ?name=foo&age[gt]=30&status[in]=a,b becomes
+//   WHERE name = 'foo' AND age > 30 AND status IN ('a','b')
+// Unknown fields, fields not in RouteOptions.FilterFields and bad operators
+// or values result in a 400 Bad Request.
+func (r *request) FilterItems() bool {
+	query := r.R.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name, op := parseFilterKey(key)
+		if reservedParams[name] {
+			continue
+		}
+		field := r.columns.lookup(name)
+		if field == nil || !r.options.canFilter(field) {
+			return r.badRequest(fmt.Errorf("Can't filter on unknown field %q", name))
+		}
+		sqlOp, ok := filterOperators[op]
+		if !ok {
+			return r.badRequest(fmt.Errorf("Unknown filter operator %q", op))
+		}
+		for _, v := range query[key] {
+			clause := fmt.Sprintf("%s.%s %s ?", r.TableName, field.DBName, sqlOp)
+			var arg interface{}
+			if op == "in" {
+				clause = fmt.Sprintf("%s.%s %s (?)", r.TableName, field.DBName, sqlOp)
+				args := make([]interface{}, 0)
+				for _, s := range strings.Split(v, ",") {
+					a, err := field.parseValue(s)
+					if err != nil {
+						return r.badRequest(fmt.Errorf("Bad value %q for field %q", s, name))
+					}
+					args = append(args, a)
+				}
+				arg = args
+			} else {
+				a, err := field.parseValue(v)
+				if err != nil {
+					return r.badRequest(fmt.Errorf("Bad value %q for field %q", v, name))
+				}
+				arg = a
+			}
+			r.DB = r.DB.Where(clause, arg)
+		}
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package grapi
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+// Check query parameters are turned into filters on the index route.
+func TestFilterItems(t *testing.T) {
+	api := getTestApi()
+	api.AddIndexRoute(&Widget{}, &RouteOptions{UriModelName: "filtered_widgets", FilterFields: []string{"name"}})
+
+	tests := []struct {
+		query string
+		count int
+	}{
+		{"id[lte]=3", 3},
+		{"name=" + url.QueryEscape("Widget 2"), 1},
+		{"id[in]=1,3", 2},
+		{"id[ne]=2&id[lt]=3", 1},
+		{"name[like]=" + url.QueryEscape("Widget%") + "&id[gte]=2&id[lte]=3", 2},
+	}
+	for _, test := range tests {
+		body := testReq(t, "Filter("+test.query+")", "GET", "/api/widgets?"+test.query, "", 200)
+		result := make([]Widget, 0)
+		json.Unmarshal([]byte(body), &result)
+		if len(result) != test.count {
+			t.Errorf("Filter %s returned %d widgets instead of %d: %v", test.query, len(result), test.count, result)
+		}
+	}
+
+	testReq(t, "Filter(Unknown field)", "GET", "/api/widgets?colour=red", "", 400)
+	testReq(t, "Filter(Bad operator)", "GET", "/api/widgets?id[near]=2", "", 400)
+	testReq(t, "Filter(Bad value)", "GET", "/api/widgets?id=two", "", 400)
+	testReq(t, "Filter(Not whitelisted)", "GET", "/api/filtered_widgets?id=2", "", 400)
+	testReq(t, "Filter(Whitelisted)", "GET", "/api/filtered_widgets?name=Widget+2", "", 200)
+}
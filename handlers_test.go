@@ -115,7 +115,7 @@ func TestCallbacks(t *testing.T) {
 			},
 			EditResult: func(req ReqFinalResult) bool {
 				tr := req.GetData().(*testRec)
-				if req.Method() == "PATCH" { //Lets not do this for GET as when we GET the index we'll have a []PrivateWidget not PrivateWidget
+				if req.Method() == "PATCH" || req.Method() == "PUT" { //Lets not do this for GET as when we GET the index we'll have a []PrivateWidget not PrivateWidget
 					tr.record(fmt.Sprintf("EditResult(%s)", req.GetResult().(*PrivateWidget).Name))
 				} else {
 					tr.record("EditResult")
@@ -126,6 +126,7 @@ func TestCallbacks(t *testing.T) {
 	// Note expected result is a marshalled json string - hence the `""` not ""
 	testMethodHandlers(t, "TestCallbacks(GET)", "GET", `"GET:Authenticate:Authorize:Query:EditResult"`)
 	testMethodHandlers(t, "TestCallbacks(POST)", "POST", `"POST:Authenticate:Authorize:CheckUpload(testname):EditResult"`)
+	testMethodHandlers(t, "TestCallbacks(PUT)", "PUT", `"PUT:Authenticate:Authorize:Query:CheckUpload(testname):EditResult(testname)"`)
 	testMethodHandlers(t, "TestCallbacks(PATCH)", "PATCH", `"PATCH:Authenticate:Authorize:Query:CheckUpload(testname):EditResult(testname)"`)
 	testMethodHandlers(t, "TestCallbacks(DELETE)", "DELETE", `"DELETE:Authenticate:Authorize:Query:EditResult"`)
 }
@@ -133,10 +134,12 @@ func TestCallbacks(t *testing.T) {
 // Test our we callback NeedsValidation interfaces appropriately.
 func TestNeedsValidation(t *testing.T) {
 	body := testReq(t, "PostItem", "POST", "/api/verified_widgets", `{"must_be_hello_world":"NewWidget"}`, 422)
-	if body != `{"errors":{"must_be_hello_horld":"Is not equal to \"Hello World!!\""}}` {
+	problem := Problem{}
+	json.Unmarshal([]byte(body), &problem)
+	if problem.Errors["must_be_hello_horld"] != `Is not equal to "Hello World!!"` {
 		t.Errorf("Didn't receive correct error message for unverified widget: %s\n", body)
 	}
-	testReq(t, "PostItem", "POST", "/api/verified_widgets", `{"must_be_hello_world":"Hello World!!"}`, 200)
+	testReq(t, "PostItem", "POST", "/api/verified_widgets", `{"must_be_hello_world":"Hello World!!"}`, 201)
 }
 
 // helper function for TestCallbacks. Call the request, and check the expected
@@ -147,12 +150,16 @@ func testMethodHandlers(t *testing.T, name string, method string, expected strin
 		body = `{"name":"testname"}`
 	}
 	uri := "/api/recordRoutes"
-	if method == "DELETE" || method == "PATCH" {
+	if method == "DELETE" || method == "PUT" || method == "PATCH" {
 		newWidget := PrivateWidget{Name: "ToDelete"}
 		getTestApi().DB().Create(&newWidget)
 		uri = fmt.Sprintf("%s/%d", uri, newWidget.ID)
 	}
-	handlers := testReq(t, name, method, uri, body, 200)
+	code := 200
+	if method == "POST" {
+		code = 201
+	}
+	handlers := testReq(t, name, method, uri, body, code)
 	if handlers != expected {
 		t.Errorf("For %s expected handler list '%s', got '%s'", method, expected, handlers)
 	} else {
@@ -189,8 +196,8 @@ func TestIndexHandlers(t *testing.T) {
 // test post handlers
 func TestPostHandlers(t *testing.T) {
 	testReq(t, "PostItem(Malformed JSON)", "POST", "/api/widgets", `{"name""NewWidget"}`, 422)
-	testReq(t, "PostItem(Existing Item ID)", "POST", "/api/widgets", `{"name":"NewWidget", "id":1}`, 422)
-	body := testReq(t, "PostItem", "POST", "/api/widgets", `{"name":"NewWidget"}`, 200)
+	testReq(t, "PostItem(Existing Item ID)", "POST", "/api/widgets", `{"name":"NewWidget", "id":1}`, 409)
+	body := testReq(t, "PostItem", "POST", "/api/widgets", `{"name":"NewWidget"}`, 201)
 	newWidget := Widget{}
 	checkWidget := Widget{}
 	json.Unmarshal([]byte(body), &newWidget)
@@ -201,6 +208,13 @@ func TestPostHandlers(t *testing.T) {
 	if checkWidget.Name != "NewWidget" {
 		t.Errorf("Didn't save new object to DB in apparently successful POST request: %v", newWidget)
 	}
+	rec := testReqWithHeaders(t, "PostItem(Location)", "POST", "/api/widgets", `{"name":"LocatedWidget"}`, nil, 201)
+	locatedWidget := Widget{}
+	json.Unmarshal(rec.Body.Bytes(), &locatedWidget)
+	if rec.Header().Get("Location") != fmt.Sprintf("/api/widgets/%d", locatedWidget.ID) {
+		t.Errorf("Wrong Location for new widget: %q", rec.Header().Get("Location"))
+	}
+	getTestApi().DB().Delete(&locatedWidget)
 	// Clear up
 	getTestApi().DB().Delete(&checkWidget)
 }
@@ -249,3 +263,20 @@ func TestDeleteHandlers(t *testing.T) {
 		t.Errorf("Record not deleted when it should have been")
 	}
 }
+
+// With EmptyResponses writes should return no body
+func TestEmptyResponses(t *testing.T) {
+	getTestApi().AddDefaultRoutes(&Widget{}, RouteOptions{UriModelName: "empty_widgets", EmptyResponses: true})
+	rec := testReqWithHeaders(t, "Empty(POST)", "POST", "/api/empty_widgets", `{"name":"Empty"}`, nil, 201)
+	location := rec.Header().Get("Location")
+	if rec.Body.Len() != 0 || location == "" {
+		t.Errorf("Expected an empty 201 with a Location, got %q %q", location, rec.Body.String())
+	}
+	testReq(t, "Empty(GET)", "GET", location, "", 200)
+	for _, method := range []string{"PATCH", "PUT", "DELETE"} {
+		if body := testReq(t, "Empty("+method+")", method, location, `{"name":"Emptier"}`, 204); body != "" {
+			t.Errorf("Got a body for %s with EmptyResponses: %s", method, body)
+		}
+	}
+	testReq(t, "Empty(GET deleted)", "GET", location, "", 404)
+}
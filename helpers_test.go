@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -114,7 +115,7 @@ func getTestApi() *Grapi {
 	}
 
 	db := getTestDb()
-	a := New(Options{JwtKey: "RandomString", Db: db, LogLevel: logLevel})
+	a := New(Options{JwtKey: "RandomString", Db: db, LogLevel: logLevel, RefreshTokenTTL: time.Hour})
 
 	a.AddDefaultRoutes(&PrivateWidget{}, RouteOptions{UseDefaultAuth: true})
 	a.AddDefaultRoutes(&Widget{})
@@ -130,12 +131,38 @@ func getTestApi() *Grapi {
 
 // Test a request to the api.
 func testReq(t *testing.T, name string, method string, path string, body string, expectedCode int) string {
-	api := getTestApi()
+	httpRecorder := testReqWithHeaders(t, name, method, path, body, nil, expectedCode)
+	if httpRecorder == nil {
+		return ""
+	}
+	return strings.TrimSpace(httpRecorder.Body.String())
+}
+
+// Test a request to the api with extra request headers, returning the recorded
+// response so the response headers can be checked too.
+func testReqWithHeaders(t *testing.T, name string, method string, path string, body string, headers map[string]string, expectedCode int) *httptest.ResponseRecorder {
+	return testApiReqWithHeaders(t, getTestApi(), name, method, path, body, headers, expectedCode)
+}
+
+// Test a request to an api other than the test api, eg. one with different Options.
+func testApiReq(t *testing.T, api *Grapi, name string, method string, path string, body string, expectedCode int) string {
+	httpRecorder := testApiReqWithHeaders(t, api, name, method, path, body, nil, expectedCode)
+	if httpRecorder == nil {
+		return ""
+	}
+	return strings.TrimSpace(httpRecorder.Body.String())
+}
+
+// testApiReqWithHeaders is testReqWithHeaders for any api.
+func testApiReqWithHeaders(t *testing.T, api *Grapi, name string, method string, path string, body string, headers map[string]string, expectedCode int) *httptest.ResponseRecorder {
 	payload := strings.NewReader(body)
 	req, err := http.NewRequest(method, path, payload)
 	if err != nil {
 		t.Errorf("Error creating request for %v: %v\n", path, err)
-		return ""
+		return nil
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
 	}
 	httpRecorder := httptest.NewRecorder()
 	api.ServeHTTP(httpRecorder, req)
@@ -145,7 +172,7 @@ func testReq(t *testing.T, name string, method string, path string, body string,
 	} else {
 		t.Errorf("%v should have code %v. Got %v and body %q\n", name, expectedCode, httpRecorder.Code, response)
 	}
-	return response
+	return httpRecorder
 }
 
 // ensurePanic is A deferrable function that fails the test with msg if there
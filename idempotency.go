@@ -0,0 +1,173 @@
+package grapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// defaultIdempotencyWindow is how long responses are kept for an Idempotency-Key
+// if Options.IdempotencyWindow isn't set.
+const defaultIdempotencyWindow = 24 * time.Hour
+
+// replayedHeaders are the response headers stored with an idempotent response.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Last-Modified"}
+
+// idempotencyKey is a request made with an Idempotency-Key header, and once it
+// has finished, the response that was sent for it. They are stored in the
+// grapi_idempotency_keys table. Keys are scoped to the client that sent them
+// (see idempotencyClient).
+type idempotencyKey struct {
+	ID        uint   `gorm:"primary_key"`
+	Key       string `sql:"unique_index:uix_grapi_idempotency_client_keys"`
+	Client    string `sql:"unique_index:uix_grapi_idempotency_client_keys"`
+	Method    string `sql:"unique_index:uix_grapi_idempotency_client_keys"`
+	Path      string `sql:"unique_index:uix_grapi_idempotency_client_keys"`
+	BodyHash  string
+	Complete  bool
+	Status    int
+	Header    string // json encoded map of replayedHeaders
+	Body      string `sql:"type:text"`
+	CreatedAt time.Time
+}
+
+// TableName keeps grapi's table out of the way of the application's own.
+func (idempotencyKey) TableName() string {
+	return "grapi_idempotency_keys"
+}
+
+// responseRecorder passes a response on to the client, keeping a copy of its
+// status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// migrateIdempotencyKeys creates the table for RouteOptions.UseIdempotencyKey. The
+// index from before keys were scoped to clients is dropped if it's there.
+func (g *Grapi) migrateIdempotencyKeys() {
+	if g.db.HasTable(&idempotencyKey{}) {
+		g.db.Model(&idempotencyKey{}).RemoveIndex("uix_grapi_idempotency_keys")
+	}
+	if err := g.db.AutoMigrate(&idempotencyKey{}).Error; err != nil {
+		log.Panicf("Can't create the grapi_idempotency_keys table: %v", err)
+	}
+}
+
+// CheckIdempotencyKey handles the Idempotency-Key header, if the route has
+// RouteOptions.UseIdempotencyKey. The first request with a key is recorded and
+// carries on as usual, and its response is stored by saveIdempotentResponse.
+// A retry with the same key and body gets the stored response again rather than
+// repeating the write. Reusing the key with a different body returns 422, and a
+// retry while the first request is still running returns 409.
+func (r *request) CheckIdempotencyKey() bool {
+	key := r.R.Header.Get("Idempotency-Key")
+	if !r.options.UseIdempotencyKey || key == "" {
+		return true
+	}
+	body := httpBody(r.R)
+	r.R.Body = ioutil.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	client := r.idempotencyClient()
+	record := idempotencyKey{Key: key, Client: client, Method: r.method, Path: r.R.URL.Path, BodyHash: hex.EncodeToString(sum[:])}
+
+	db := r.api.db
+	window := r.api.options.IdempotencyWindow
+	db.Where("created_at < ?", time.Now().Add(-window)).Delete(&idempotencyKey{})
+	if err := db.Create(&record).Error; err == nil {
+		r.idempotency = &record
+		r.W = &responseRecorder{ResponseWriter: r.W}
+		return true
+	}
+
+	stored := idempotencyKey{}
+	find := db.Where(map[string]interface{}{"key": key, "client": client, "method": r.method, "path": r.R.URL.Path})
+	if err := find.First(&stored).Error; err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Can't store idempotency key")
+		return r.problem(500, "Database error")
+	}
+	logger := log.WithFields(log.Fields{"key": key, "client": client, "method": r.method, "path": r.R.URL.Path})
+	switch {
+	case stored.BodyHash != record.BodyHash:
+		logger.Warn("Idempotency key reused with a different body")
+		return r.problem(422, "Idempotency-Key has already been used for a different request")
+	case !stored.Complete:
+		logger.Info("Idempotency key in use")
+		return r.problem(409, "A request with this Idempotency-Key is still in progress")
+	}
+	logger.Info("Replaying idempotent response")
+	header := make(map[string]string)
+	json.Unmarshal([]byte(stored.Header), &header)
+	for k, v := range header {
+		r.W.Header().Set(k, v)
+	}
+	r.W.Header().Set("Idempotent-Replayed", "true")
+	r.W.WriteHeader(stored.Status)
+	r.W.Write([]byte(stored.Body))
+	return false
+}
+
+// idempotencyClient returns who sent the request, so that two clients can use the
+// same Idempotency-Key. This is the subject of the token checked by the default
+// authenticator, or the ID field of the login object set by a custom Authenticate.
+// It is "" for anonymous requests, and for login objects without an ID, which
+// therefore share their keys: nothing else about a login object is sure to be the
+// same from one request to the next.
+func (r *request) idempotencyClient() string {
+	if sub := claimsSubject(r.claims); sub != "" {
+		return sub
+	}
+	if id, err := getID(r.LoginObject); err == nil {
+		return fmt.Sprint(id)
+	}
+	return ""
+}
+
+// saveIdempotentResponse stores the response to a request with an Idempotency-Key,
+// to be replayed on retries. Handlers defer it. If the request failed with a server
+// error, or panicked, the key is forgotten instead so that the client can retry.
+func (r *request) saveIdempotentResponse() {
+	if r.idempotency == nil {
+		return
+	}
+	rec := r.W.(*responseRecorder)
+	db := r.api.db
+	if rec.status == 0 || rec.status >= 500 {
+		db.Delete(r.idempotency)
+		return
+	}
+	header := make(map[string]string)
+	for _, k := range replayedHeaders {
+		if v := rec.Header().Get(k); v != "" {
+			header[k] = v
+		}
+	}
+	j, _ := json.Marshal(header)
+	err := db.Model(r.idempotency).Updates(map[string]interface{}{
+		"complete": true, "status": rec.status, "header": string(j), "body": rec.body.String()}).Error
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Can't save idempotent response")
+		db.Delete(r.idempotency)
+	}
+}
@@ -0,0 +1,92 @@
+package grapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	db.DropTable(&idempotencyKey{})
+	api.AddDefaultRoutes(&Widget{}, RouteOptions{UriModelName: "idempotent_widgets", UseIdempotencyKey: true, Bulk: true})
+
+	count := func(name string) (n int) {
+		db.Model(&Widget{}).Where("name = ?", name).Count(&n)
+		return
+	}
+	post := func(name string, key string, body string, code int) string {
+		rec := testReqWithHeaders(t, name, "POST", "/api/idempotent_widgets", body, map[string]string{"Idempotency-Key": key}, code)
+		return rec.Body.String()
+	}
+
+	first := post("Idempotency(POST)", "key-1", `{"name":"Idempotent"}`, 201)
+	retry := post("Idempotency(retry)", "key-1", `{"name":"Idempotent"}`, 201)
+	if first != retry {
+		t.Errorf("Retry didn't replay the response: %s != %s", first, retry)
+	}
+	if n := count("Idempotent"); n != 1 {
+		t.Errorf("Retry created a duplicate: %d items", n)
+	}
+	post("Idempotency(different body)", "key-1", `{"name":"Sneaky"}`, 422)
+	post("Idempotency(new key)", "key-2", `{"name":"Idempotent"}`, 201)
+	if n := count("Idempotent"); n != 2 {
+		t.Errorf("A new key should create another item: %d items", n)
+	}
+	testReq(t, "Idempotency(no key)", "POST", "/api/idempotent_widgets", `{"name":"Idempotent"}`, 201)
+
+	post("Idempotency(bulk)", "key-3", `[{"name":"Idempotent Bulk"},{"name":"Idempotent Bulk"}]`, 201)
+	post("Idempotency(bulk retry)", "key-3", `[{"name":"Idempotent Bulk"},{"name":"Idempotent Bulk"}]`, 201)
+	if n := count("Idempotent Bulk"); n != 2 {
+		t.Errorf("Bulk retry created duplicates: %d items", n)
+	}
+
+	// Client errors are replayed too, and a retry can't run alongside the original
+	post("Idempotency(failed)", "key-4", `{"name":`, 422)
+	post("Idempotency(failed retry)", "key-4", `{"name":`, 422)
+	if db.Where("key = ? AND complete = ?", "key-4", true).First(&idempotencyKey{}).RecordNotFound() {
+		t.Errorf("The response to a client error should be kept")
+	}
+	sum := sha256.Sum256([]byte(`{"name":"Running"}`))
+	db.Create(&idempotencyKey{Key: "key-5", Method: "POST", Path: "/api/idempotent_widgets", BodyHash: hex.EncodeToString(sum[:])})
+	post("Idempotency(in progress)", "key-5", `{"name":"Running"}`, 409)
+
+	db.Where("name in (?)", []string{"Idempotent", "Idempotent Bulk"}).Delete(&Widget{})
+}
+
+func TestIdempotencyKeyPerClient(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	db.DropTable(&idempotencyKey{})
+	api.AddPostRoute(&PrivateWidget{}, &RouteOptions{UriModelName: "idempotent_private_widgets", UseIdempotencyKey: true, UseDefaultAuth: true})
+	other := User{Name: "idempotent_other", Password: "password"}
+	db.Create(&other)
+	defer db.Delete(&other)
+
+	post := func(name string, user string, code int) PrivateWidget {
+		token := getToken(testReq(t, name+"(Login)", "POST", "/api/auth", `{"name": "`+user+`", "password": "password"}`, 200))
+		rec := testReqWithHeaders(t, name, "POST", "/api/idempotent_private_widgets?access_token="+token,
+			`{"name":"Shared Key"}`, map[string]string{"Idempotency-Key": "shared-key"}, code)
+		widget := PrivateWidget{}
+		json.Unmarshal(rec.Body.Bytes(), &widget)
+		return widget
+	}
+	first := post("Idempotency(first client)", "admin", 201)
+	second := post("Idempotency(second client)", "idempotent_other", 201)
+	if first.ID == 0 || first.ID == second.ID {
+		t.Errorf("The second client was replayed the first client's response: %+v %+v", first, second)
+	}
+	if retry := post("Idempotency(first client retry)", "admin", 201); retry.ID != first.ID {
+		t.Errorf("A retry should replay the client's own response: %+v != %+v", retry, first)
+	}
+	db.Where("name = ?", "Shared Key").Delete(&PrivateWidget{})
+
+	for lo, client := range map[interface{}]string{nil: "", &User{ID: 3}: "3", &struct{ Name string }{"no id"}: ""} {
+		r := request{LoginObject: lo}
+		if c := r.idempotencyClient(); c != client {
+			t.Errorf("Client for login object %+v should be %q, got %q", lo, client, c)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package grapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	reservedParams["include"] = true
+}
+
+// associationPath converts a dotted path of json association names such as
+// "private_widgets.parts" into the path of Go field names that gorm's Preload
+// expects, eg. "PrivateWidgets.Parts".
+func (g *Grapi) associationPath(t reflect.Type, path string) (string, error) {
+	goNames := make([]string, 0)
+	for _, name := range strings.Split(path, ".") {
+		for t.Kind() == reflect.Slice || t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		var found *reflect.StructField
+		scope := g.db.NewScope(reflect.New(t).Interface())
+		for _, sf := range scope.GetModelStruct().StructFields {
+			if sf.Relationship != nil && jsonFieldName(sf.Struct) == name {
+				found = &sf.Struct
+				break
+			}
+		}
+		if found == nil {
+			return "", fmt.Errorf("%v has no association %q", t, name)
+		}
+		goNames = append(goNames, found.Name)
+		t = found.Type
+	}
+	return strings.Join(goNames, "."), nil
+}
+
+// includePaths maps each of RouteOptions.Includes, and each path leading up to
+// them, to the equivalent gorm Preload path for the model type t. It panics on
+// an unknown association as it is called when routes are set up.
+func (ro *RouteOptions) includePaths(g *Grapi, t reflect.Type) map[string]string {
+	paths := make(map[string]string)
+	for _, include := range ro.Includes {
+		parts := strings.Split(include, ".")
+		for i := range parts {
+			path := strings.Join(parts[:i+1], ".")
+			preload, err := g.associationPath(t, path)
+			if err != nil {
+				log.Panicf("Bad RouteOptions.Includes: %v", err)
+			}
+			paths[path] = preload
+		}
+	}
+	return paths
+}
+
+// IncludeAssociations preloads the associations named in the ?include= query
+// parameter, eg. ?include=private_widgets,private_widgets.parts . Only the
+// associations allowed by RouteOptions.Includes can be used. The associations
+// are loaded for the items found by r.DB, so they remain limited by any scoping
+// done in the Query callback.
+func (r *request) IncludeAssociations() bool {
+	param := r.R.URL.Query().Get("include")
+	if param == "" {
+		return true
+	}
+	r.included = make(map[string]bool)
+	for _, include := range strings.Split(param, ",") {
+		preload, ok := r.includes[include]
+		if !ok {
+			return r.badRequest(fmt.Errorf("Can't include %q", include))
+		}
+		r.DB = r.DB.Preload(preload)
+		r.included[strings.Split(include, ".")[0]] = true
+	}
+	return true
+}
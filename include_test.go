@@ -0,0 +1,75 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// Models with nested has-many associations for testing ?include=
+type Shelf struct {
+	ID    uint   `gorm:"primary_key" json:"id"`
+	Name  string `json:"name"`
+	Boxes []Box  `json:"boxes"`
+}
+
+type Box struct {
+	ID      uint      `gorm:"primary_key" json:"id"`
+	ShelfID uint      `json:"shelf_id"`
+	Name    string    `json:"name"`
+	Items   []BoxItem `json:"items"`
+}
+
+type BoxItem struct {
+	ID    uint   `gorm:"primary_key" json:"id"`
+	BoxID uint   `json:"box_id"`
+	Name  string `json:"name"`
+}
+
+func TestIncludeAssociations(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	for _, model := range []interface{}{&Shelf{}, &Box{}, &BoxItem{}} {
+		db.DropTable(model)
+		db.CreateTable(model)
+	}
+	db.Create(&Shelf{Name: "Top", Boxes: []Box{{Name: "Box 1", Items: []BoxItem{{Name: "Item 1"}, {Name: "Item 2"}}}, {Name: "Box 2"}}})
+	db.Create(&Shelf{Name: "Bottom", Boxes: []Box{{Name: "Box 3"}}})
+
+	api.AddDefaultRoutes(&Shelf{}, RouteOptions{
+		Includes: []string{"boxes.items"},
+		Query: func(req ReqToLimit) bool {
+			req.SetDB(req.GetDB().Where("name = ?", "Top"))
+			return true
+		}})
+
+	shelves := make([]Shelf, 0)
+	body := testReq(t, "Include(none)", "GET", "/api/shelves", "", 200)
+	json.Unmarshal([]byte(body), &shelves)
+	if len(shelves) != 1 || len(shelves[0].Boxes) != 0 {
+		t.Errorf("Loaded associations without being asked: %s", body)
+	}
+	body = testReq(t, "Include(boxes)", "GET", "/api/shelves?include=boxes", "", 200)
+	json.Unmarshal([]byte(body), &shelves)
+	if len(shelves) != 1 || len(shelves[0].Boxes) != 2 || len(shelves[0].Boxes[0].Items) != 0 {
+		t.Errorf("Didn't include only the boxes of the top shelf: %s", body)
+	}
+	shelf := Shelf{}
+	body = testReq(t, "Include(nested)", "GET", fmt.Sprintf("/api/shelves/%d?include=boxes.items", shelves[0].ID), "", 200)
+	json.Unmarshal([]byte(body), &shelf)
+	if len(shelf.Boxes) != 2 || len(shelf.Boxes[0].Items) != 2 {
+		t.Errorf("Didn't include nested items: %s", body)
+	}
+	body = testReq(t, "Include(with fields)", "GET", fmt.Sprintf("/api/shelves/%d?include=boxes&fields=boxes", shelves[0].ID), "", 200)
+	var m map[string]interface{}
+	json.Unmarshal([]byte(body), &m)
+	if boxes, ok := m["boxes"].([]interface{}); len(m) != 1 || !ok || len(boxes) != 2 {
+		t.Errorf("Didn't get only the included boxes: %s", body)
+	}
+
+	testReq(t, "Include(not allowed)", "GET", "/api/users?include=private_widgets", "", 400)
+	testReq(t, "Include(unknown)", "GET", "/api/shelves?include=cupboards", "", 400)
+
+	defer ensurePanic(t, "Added a route with an unknown association in Includes")
+	api.AddIndexRoute(&Shelf{}, &RouteOptions{UriModelName: "bad_shelves", Includes: []string{"boxes.lids"}})
+}
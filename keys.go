@@ -0,0 +1,196 @@
+package grapi
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji"
+	"github.com/zenazn/goji/web"
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// jwksPath is where the public keys in Options.SigningKeys are published.
+const jwksPath = "/.well-known/jwks.json"
+
+// SigningKey is a key for signing and verifying access tokens. See
+// Options.SigningKeys. eg.
+//
+//	grapi.SigningKey{ID: "2017-03", Method: jwt.SigningMethodRS256, PrivateKey: rsaKey}
+type SigningKey struct {
+	ID     string            // Sent as the kid header of tokens, to pick the key to verify them with
+	Method jwt.SigningMethod // eg. jwt.SigningMethodRS256, jwt.SigningMethodES256 or grapi.SigningMethodEdDSA
+	// PrivateKey is an *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey (or []byte
+	// for HMAC). It may be nil for a key that is only used to verify tokens.
+	PrivateKey interface{}
+	// PublicKey is the matching *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey. It
+	// can be left out if PrivateKey is given.
+	PublicKey interface{}
+}
+
+// SigningMethodEdDSA signs tokens with Ed25519 keys, which jwt-go doesn't support.
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return errors.New("EdDSA needs an ed25519.PublicKey")
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return errors.New("EdDSA signature is invalid")
+	}
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", errors.New("EdDSA needs an ed25519.PrivateKey")
+	}
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
+
+// initSigningKeys checks the keys in Options.SigningKeys, and fills in their
+// public keys. Private keys are tried out so that a key which doesn't suit its
+// Method is found now rather than at login.
+func (o *Options) initSigningKeys() {
+	for i := range o.SigningKeys {
+		k := &o.SigningKeys[i]
+		if k.Method == nil || k.ID == "" {
+			log.Panicf("Signing key %d needs an ID and Method", i)
+		}
+		if k.PublicKey == nil {
+			if signer, ok := k.PrivateKey.(crypto.Signer); ok {
+				k.PublicKey = signer.Public()
+			} else {
+				k.PublicKey = k.PrivateKey // HMAC
+			}
+		}
+		if k.PublicKey == nil {
+			log.Panicf("Signing key %s needs a PublicKey or PrivateKey", k.ID)
+		}
+		if k.PrivateKey != nil {
+			if _, err := k.Method.Sign("test", k.PrivateKey); err != nil {
+				log.Panicf("Signing key %s can't sign with %s: %v", k.ID, k.Method.Alg(), err)
+			}
+		}
+	}
+}
+
+// signingKey returns the key to sign new access tokens with. This is the first of
+// Options.SigningKeys with a private key, or if there are none an HMAC key made
+// from Options.JwtKey.
+func (g *Grapi) signingKey() SigningKey {
+	for _, k := range g.options.SigningKeys {
+		if k.PrivateKey != nil {
+			return k
+		}
+	}
+	return SigningKey{Method: jwt.SigningMethodHS256, PrivateKey: []byte(g.options.JwtKey)}
+}
+
+// verificationKey is the jwt.Keyfunc for access tokens. Without Options.SigningKeys
+// tokens must be signed with HMAC using Options.JwtKey. Otherwise they must have the
+// kid of one of the keys, and be signed with its method.
+func (g *Grapi) verificationKey(token *jwt.Token) (interface{}, error) {
+	keys := g.options.SigningKeys
+	if len(keys) == 0 {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			log.WithFields(log.Fields{"method": token.Header["alg"]}).Warn("JWT Auth: Unexpected signing method.")
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(g.options.JwtKey), nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	for _, k := range keys {
+		if k.ID != kid {
+			continue
+		}
+		if k.Method.Alg() != token.Method.Alg() {
+			log.WithFields(log.Fields{"method": token.Header["alg"], "kid": kid}).Warn("JWT Auth: Unexpected signing method.")
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return k.PublicKey, nil
+	}
+	log.WithFields(log.Fields{"kid": kid}).Warn("JWT Auth: Unknown key.")
+	return nil, fmt.Errorf("Unknown key: %q", kid)
+}
+
+// jwk returns the public key as a JSON Web Key (RFC 7517), or nil if it can't be
+// published (ie. an HMAC key).
+func (k *SigningKey) jwk() map[string]string {
+	j := map[string]string{"kid": k.ID, "alg": k.Method.Alg(), "use": "sig"}
+	switch pub := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		j["kty"] = "RSA"
+		j["n"] = jwt.EncodeSegment(pub.N.Bytes())
+		j["e"] = jwt.EncodeSegment(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		j["kty"] = "EC"
+		j["crv"] = pub.Curve.Params().Name
+		j["x"] = jwt.EncodeSegment(padBytes(pub.X.Bytes(), size))
+		j["y"] = jwt.EncodeSegment(padBytes(pub.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		j["kty"] = "OKP"
+		j["crv"] = "Ed25519"
+		j["x"] = jwt.EncodeSegment(pub)
+	default:
+		return nil
+	}
+	return j
+}
+
+// padBytes left pads b with zeros to size bytes.
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// addJWKSRoute publishes the public keys of Options.SigningKeys at
+// /.well-known/jwks.json, so that other services can verify our tokens.
+func (g *Grapi) addJWKSRoute() {
+	log.Infof("Publishing signing keys at %s", jwksPath)
+	g.router.Get(jwksPath, g.jwksHandler())
+	goji.Handle(jwksPath, g.router)
+}
+
+// jwksHandler returns the handler for /.well-known/jwks.json
+func (g *Grapi) jwksHandler() web.HandlerType {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		keys := make([]map[string]string, 0)
+		for i := range g.options.SigningKeys {
+			if j := g.options.SigningKeys[i].jwk(); j != nil {
+				keys = append(keys, j)
+			}
+		}
+		body, _ := json.Marshal(map[string]interface{}{"keys": keys})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
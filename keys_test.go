@@ -0,0 +1,76 @@
+package grapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+func TestSigningKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ed := SigningKey{ID: "ed-1", Method: SigningMethodEdDSA, PrivateKey: edKey}
+	rsaOld := SigningKey{ID: "rsa-1", Method: jwt.SigningMethodRS256, PublicKey: &rsaKey.PublicKey}
+	ec := SigningKey{ID: "ec-1", Method: jwt.SigningMethodES256, PublicKey: &ecKey.PublicKey}
+	api := New(Options{Db: getTestDb(), UriPrefix: "/keys_api", SigningKeys: []SigningKey{ed, rsaOld, ec}})
+	api.SetAuth(&User{}, "login")
+	api.AddDefaultRoutes(&PrivateWidget{}, RouteOptions{UseDefaultAuth: true})
+
+	auth := func(name string, token string, code int) {
+		testApiReq(t, api, name, "GET", "/keys_api/private_widgets?access_token="+token, "", code)
+	}
+	sign := func(key SigningKey) string {
+		token, err := getJWTToken("1", nil, key, time.Hour)
+		if err != nil {
+			t.Errorf("Can't sign token with %s: %v", key.ID, err)
+		}
+		return token
+	}
+
+	token := getToken(testApiReq(t, api, "Keys(Login)", "POST", "/keys_api/login", `{"name": "admin", "password": "password"}`, 200))
+	parsed, _ := jwt.Parse(token, api.verificationKey)
+	if parsed == nil || !parsed.Valid || parsed.Header["kid"] != "ed-1" || parsed.Header["alg"] != "EdDSA" {
+		t.Errorf("Login should sign with the first key: %v", parsed)
+	}
+	auth("Keys(EdDSA)", token, 200)
+	auth("Keys(Older RSA key)", sign(SigningKey{ID: "rsa-1", Method: jwt.SigningMethodRS256, PrivateKey: rsaKey}), 200)
+	auth("Keys(ECDSA)", sign(SigningKey{ID: "ec-1", Method: jwt.SigningMethodES256, PrivateKey: ecKey}), 200)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	auth("Keys(Wrong key)", sign(SigningKey{ID: "ec-1", Method: jwt.SigningMethodES256, PrivateKey: otherKey}), 401)
+	auth("Keys(Unknown kid)", sign(SigningKey{ID: "ec-2", Method: jwt.SigningMethodES256, PrivateKey: ecKey}), 401)
+	auth("Keys(Wrong alg for kid)", sign(SigningKey{ID: "ed-1", Method: jwt.SigningMethodHS256, PrivateKey: []byte(edKey.Public().(ed25519.PublicKey))}), 401)
+	auth("Keys(HMAC without kid)", sign(SigningKey{Method: jwt.SigningMethodHS256, PrivateKey: []byte("RandomString")}), 401)
+
+	jwks := struct {
+		Keys []map[string]string `json:"keys"`
+	}{}
+	json.Unmarshal([]byte(testApiReq(t, api, "Keys(JWKS)", "GET", "/.well-known/jwks.json", "", 200)), &jwks)
+	kty := make(map[string]string)
+	for _, k := range jwks.Keys {
+		kty[k["kid"]] = k["kty"]
+	}
+	if len(jwks.Keys) != 3 || kty["ed-1"] != "OKP" || kty["rsa-1"] != "RSA" || kty["ec-1"] != "EC" {
+		t.Errorf("JWKS should publish all the public keys: %v", jwks)
+	}
+
+	defer ensurePanic(t, "Created an API with a signing key without a method")
+	New(Options{Db: getTestDb(), UriPrefix: "/bad_keys_api", SigningKeys: []SigningKey{{ID: "bad", PrivateKey: edKey}}})
+}
+
+func TestSigningKeyMismatch(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key := SigningKey{ID: "rsa-ec", Method: jwt.SigningMethodRS256, PrivateKey: ecKey}
+	if token, err := getJWTToken("1", nil, key, time.Hour); err == nil {
+		t.Errorf("Signing with a key that doesn't suit its method should fail: %q", token)
+	}
+	defer ensurePanic(t, "Created an API with a signing key that doesn't suit its method")
+	New(Options{Db: getTestDb(), UriPrefix: "/mismatched_keys_api", SigningKeys: []SigningKey{key}})
+}
@@ -0,0 +1,230 @@
+package grapi
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/serenize/snaker"
+)
+
+// nestedRoute holds what we need to know about a has-many association in order
+// to serve the children under their parent's uri.
+type nestedRoute struct {
+	api           *Grapi
+	parentType    reflect.Type
+	parentTable   string
+	parentOptions *RouteOptions
+	param         string // The url param holding the parent's id, eg. "user_id"
+	parentKey     string // The name of the parent's field that the foreign key refers to, eg. "ID"
+	parentKeyDB   string // The database column of parentKey, eg. "id"
+	foreignKey    string // The name of the child's foreign key field, eg. "UserID"
+	foreignKeyDB  string // The database column of foreignKey, eg. "user_id"
+	childTable    string
+}
+
+// AddNestedRoutes adds the default REST routes for childP underneath the routes
+// for parentP, for a parent that has many children. eg. if a User has many
+// PrivateWidgets then
+//   g.AddNestedRoutes(&User{}, &PrivateWidget{}, nil)
+// adds GET, POST, PUT, PATCH and DELETE routes at /api/users/:user_id/private_widgets
+// as in AddDefaultRoutes. The foreign key is found from gorm's association between
+// the models. The routes are scoped to the children of the parent, the parent's
+// id is forced into the foreign key of POSTed (or PUT) children, and PATCH can't
+// move a child to another parent.
+//
+// If the parent doesn't exist, or is hidden by the Query callback of parentOptions
+// (usually the RouteOptions used for the parent's own routes), a 404 is returned.
+// Prefix and UriModelName in parentOptions are used to build the parent's part of
+// the path. options are the RouteOptions for the children, as in AddDefaultRoutes.
+func (g *Grapi) AddNestedRoutes(parentP interface{}, childP interface{}, parentOptions *RouteOptions, options ...RouteOptions) {
+	if parentOptions == nil {
+		parentOptions = &RouteOptions{}
+	}
+	if len(options) > 3 {
+		panic("AddNestedRoutes called with more than 3 RouteOptions")
+	}
+	if len(options) == 0 {
+		options = []RouteOptions{{}}
+	}
+	nr := g.newNestedRoute(parentP, childP, parentOptions)
+	prefix := parentOptions.Prefix + "/" + g.modelName(parentP, parentOptions) + "/:" + nr.param
+	nestedOptions := make([]RouteOptions, len(options))
+	for i, o := range options {
+		nestedOptions[i] = nr.wrapOptions(o, prefix)
+	}
+	g.AddDefaultRoutes(childP, nestedOptions...)
+}
+
+// newNestedRoute finds the has-many association between the parent and child types.
+func (g *Grapi) newNestedRoute(parentP interface{}, childP interface{}, parentOptions *RouteOptions) *nestedRoute {
+	parentType := reflect.TypeOf(parentP).Elem()
+	childType := reflect.TypeOf(childP).Elem()
+	scope := g.db.NewScope(parentP)
+	for _, sf := range scope.GetModelStruct().StructFields {
+		rel := sf.Relationship
+		if rel == nil || rel.Kind != "has_many" || len(rel.ForeignFieldNames) != 1 {
+			continue
+		}
+		t := sf.Struct.Type
+		for t.Kind() == reflect.Slice || t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t != childType {
+			continue
+		}
+		parentKeyDB := rel.AssociationForeignDBNames[0]
+		parentKey := rel.AssociationForeignFieldNames[0]
+		if field, ok := scope.FieldByName(parentKey); ok {
+			parentKey = field.Name
+		}
+		foreignKey := rel.ForeignFieldNames[0]
+		if field, ok := g.db.NewScope(childP).FieldByName(foreignKey); ok {
+			foreignKey = field.Name
+		}
+		parentField, pok := parentType.FieldByName(parentKey)
+		childField, cok := childType.FieldByName(foreignKey)
+		if pok && cok && !canSetForeignKey(childField.Type, parentField.Type) {
+			log.Panicf("Can't set %v.%v (%v) to the parent's %v (%v)", childType, foreignKey, childField.Type, parentKey, parentField.Type)
+		}
+		return &nestedRoute{
+			api:           g,
+			parentType:    parentType,
+			parentTable:   pluralCamelNameType(parentType),
+			parentOptions: parentOptions,
+			param:         snaker.CamelToSnake(parentType.Name()) + "_id",
+			parentKey:     parentKey,
+			parentKeyDB:   parentKeyDB,
+			foreignKey:    foreignKey,
+			foreignKeyDB:  rel.ForeignDBNames[0],
+			childTable:    pluralCamelNameType(childType),
+		}
+	}
+	log.Panicf("%v does not have many %v", parentType, childType)
+	return nil
+}
+
+// wrapOptions returns a copy of the child's RouteOptions with the parent scoping
+// added to its Query and CheckUpload callbacks.
+func (nr *nestedRoute) wrapOptions(o RouteOptions, prefix string) RouteOptions {
+	o.Prefix = prefix + o.Prefix
+	query := o.Query
+	o.Query = func(req ReqToLimit) bool {
+		return nr.scopeToParent(req.(*request)) && (query == nil || query(req))
+	}
+	checkUpload := o.CheckUpload
+	o.CheckUpload = func(req ReqULToCheck) bool {
+		return nr.checkForeignKey(req.(*request)) && (checkUpload == nil || checkUpload(req))
+	}
+	return o
+}
+
+// findParent loads the parent named in the url, using the parent's own Query
+// callback so the parent's scoping applies. It writes a 404 if there's no such
+// parent.
+func (nr *nestedRoute) findParent(r *request) bool {
+	params := make(map[string]string)
+	for k, v := range r.C.URLParams {
+		params[k] = v
+	}
+	params["id"] = r.Param(nr.param)
+	pr := *r
+	pr.C.URLParams = params
+	pr.Type = nr.parentType
+	pr.TableName = nr.parentTable
+	pr.options = nr.parentOptions
+	if nr.parentOptions.Query != nil && !nr.parentOptions.Query(&pr) {
+		r.failure = pr.failure
+		return false
+	}
+	r.Data = pr.Data
+	parent := reflect.New(nr.parentType).Interface()
+	qstring := fmt.Sprintf("%s.%s = ?", nr.parentTable, nr.parentKeyDB)
+	if pr.DB.Where(qstring, params["id"]).Find(parent).RecordNotFound() {
+		log.WithFields(log.Fields{"parent": nr.parentType, "id": params["id"]}).Info("Parent not found")
+		return r.problem(404, "")
+	}
+	r.parent = parent
+	return true
+}
+
+// scopeToParent checks the parent exists, and limits r.DB to its children.
+func (nr *nestedRoute) scopeToParent(r *request) bool {
+	if !nr.findParent(r) {
+		return false
+	}
+	qstring := fmt.Sprintf("%s.%s = ?", nr.childTable, nr.foreignKeyDB)
+	r.DB = r.DB.Where(qstring, nr.parentKeyValue(r).Interface())
+	return true
+}
+
+// parentKeyValue returns the value of the parent's key that the children's
+// foreign key must hold.
+func (nr *nestedRoute) parentKeyValue(r *request) reflect.Value {
+	return reflect.ValueOf(r.parent).Elem().FieldByName(nr.parentKey)
+}
+
+// checkForeignKey sets the foreign key of a POSTed (or PUT) child to the parent's
+// id, or checks that a PATCH hasn't changed it.
+func (nr *nestedRoute) checkForeignKey(r *request) bool {
+	if r.parent == nil && !nr.findParent(r) {
+		return false
+	}
+	fk := reflect.ValueOf(r.Uploaded).Elem().FieldByName(nr.foreignKey)
+	key := nr.parentKeyValue(r)
+	if r.method == "POST" || r.method == "PUT" {
+		if err := setForeignKey(fk, key); err != nil {
+			log.WithFields(log.Fields{"error": err, "key": key.Interface()}).Error("Can't set foreign key")
+			return r.problem(500, "")
+		}
+		return true
+	}
+	if fmt.Sprint(foreignKeyValue(fk)) != fmt.Sprint(key.Interface()) {
+		log.WithFields(log.Fields{"from": key.Interface(), "to": foreignKeyValue(fk)}).Warn("Trying to move child to another parent")
+		return r.problem(422, "Can't move to another parent")
+	}
+	return true
+}
+
+// canSetForeignKey returns true if setForeignKey can put a parent's key of type
+// key into a foreign key of type fk, which may be a pointer (eg. *uint) or an
+// sql.Scanner (eg. sql.NullInt64) for an optional parent.
+func canSetForeignKey(fk reflect.Type, key reflect.Type) bool {
+	if fk.Kind() == reflect.Ptr {
+		fk = fk.Elem()
+	}
+	return key.ConvertibleTo(fk) || reflect.PtrTo(fk).Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem())
+}
+
+// setForeignKey sets the foreign key field fk to the parent's key.
+func setForeignKey(fk reflect.Value, key reflect.Value) error {
+	if fk.Kind() == reflect.Ptr {
+		if fk.IsNil() {
+			fk.Set(reflect.New(fk.Type().Elem()))
+		}
+		fk = fk.Elem()
+	}
+	if key.Type().ConvertibleTo(fk.Type()) {
+		fk.Set(key.Convert(fk.Type()))
+		return nil
+	}
+	return fk.Addr().Interface().(sql.Scanner).Scan(key.Interface())
+}
+
+// foreignKeyValue returns the value held by the foreign key field fk, or nil
+// if it doesn't refer to a parent.
+func foreignKeyValue(fk reflect.Value) interface{} {
+	if fk.Kind() == reflect.Ptr {
+		if fk.IsNil() {
+			return nil
+		}
+		fk = fk.Elem()
+	}
+	if valuer, ok := fk.Interface().(driver.Valuer); ok {
+		v, _ := valuer.Value()
+		return v
+	}
+	return fk.Interface()
+}
@@ -0,0 +1,115 @@
+package grapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// A Library's books and labels have optional parents.
+type Library struct {
+	ID     uint           `gorm:"primary_key" json:"id"`
+	Books  []LibraryBook  `json:"books"`
+	Labels []LibraryLabel `json:"labels"`
+}
+
+type LibraryBook struct {
+	ID        uint   `gorm:"primary_key" json:"id"`
+	LibraryID *uint  `json:"library_id"`
+	Name      string `json:"name"`
+}
+
+type LibraryLabel struct {
+	ID        uint          `gorm:"primary_key" json:"id"`
+	LibraryID sql.NullInt64 `json:"-"`
+	Name      string        `json:"name"`
+}
+
+func TestNestedRoutes(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	visible := User{Name: "nested_visible"}
+	hidden := User{Name: "nested_hidden"}
+	db.Create(&visible)
+	db.Create(&hidden)
+	widget := PrivateWidget{UserID: visible.ID, Name: "Nested Widget"}
+	hiddenWidget := PrivateWidget{UserID: hidden.ID, Name: "Hidden Nested Widget"}
+	db.Create(&widget)
+	db.Create(&hiddenWidget)
+
+	api.AddNestedRoutes(&User{}, &PrivateWidget{}, &RouteOptions{
+		UriModelName: "nested_users",
+		Query: func(req ReqToLimit) bool {
+			req.SetDB(req.GetDB().Where("name <> ?", "nested_hidden"))
+			return true
+		}})
+	base := fmt.Sprintf("/api/nested_users/%d/private_widgets", visible.ID)
+	hiddenBase := fmt.Sprintf("/api/nested_users/%d/private_widgets", hidden.ID)
+
+	widgets := make([]PrivateWidget, 0)
+	body := testReq(t, "Nested(index)", "GET", base, "", 200)
+	json.Unmarshal([]byte(body), &widgets)
+	found := false
+	for _, w := range widgets {
+		found = found || w.ID == widget.ID
+		if w.UserID != visible.ID {
+			t.Errorf("Got another parent's widget: %v", w)
+		}
+	}
+	if !found {
+		t.Errorf("Didn't get the parent's widget: %s", body)
+	}
+	testReq(t, "Nested(item)", "GET", fmt.Sprintf("%s/%d", base, widget.ID), "", 200)
+	testReq(t, "Nested(other parent's item)", "GET", fmt.Sprintf("%s/%d", base, hiddenWidget.ID), "", 404)
+	testReq(t, "Nested(hidden parent)", "GET", hiddenBase, "", 404)
+	testReq(t, "Nested(missing parent)", "GET", "/api/nested_users/9999/private_widgets", "", 404)
+	testReq(t, "Nested(POST to hidden parent)", "POST", hiddenBase, `{"name":"Sneaky"}`, 404)
+
+	created := PrivateWidget{}
+	body = testReq(t, "Nested(POST)", "POST", base, fmt.Sprintf(`{"name":"New Nested","user_id":%d}`, hidden.ID), 201)
+	json.Unmarshal([]byte(body), &created)
+	if created.UserID != visible.ID {
+		t.Errorf("POST didn't force the parent's id into the foreign key: %s", body)
+	}
+	testReq(t, "Nested(PATCH move)", "PATCH", fmt.Sprintf("%s/%d", base, created.ID), fmt.Sprintf(`{"user_id":%d}`, hidden.ID), 422)
+	testReq(t, "Nested(PATCH)", "PATCH", fmt.Sprintf("%s/%d", base, created.ID), `{"name":"Renamed"}`, 200)
+	testReq(t, "Nested(DELETE other parent's item)", "DELETE", fmt.Sprintf("%s/%d", base, hiddenWidget.ID), "", 404)
+	testReq(t, "Nested(DELETE)", "DELETE", fmt.Sprintf("%s/%d", base, created.ID), "", 200)
+
+	defer ensurePanic(t, "Added nested routes for models without an association")
+	api.AddNestedRoutes(&User{}, &Widget{}, nil)
+}
+
+func TestNestedOptionalParent(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	db.AutoMigrate(&Library{}, &LibraryBook{}, &LibraryLabel{})
+	library, other := Library{}, Library{}
+	db.Create(&library)
+	db.Create(&other)
+	api.AddNestedRoutes(&Library{}, &LibraryBook{}, nil)
+	api.AddNestedRoutes(&Library{}, &LibraryLabel{}, nil)
+	books := fmt.Sprintf("/api/libraries/%d/library_books", library.ID)
+
+	book := LibraryBook{}
+	body := testReq(t, "Nested(POST pointer key)", "POST", books, `{"name":"Book"}`, 201)
+	json.Unmarshal([]byte(body), &book)
+	if book.LibraryID == nil || *book.LibraryID != library.ID {
+		t.Errorf("POST didn't set the pointer foreign key: %s", body)
+	}
+	item := fmt.Sprintf("%s/%d", books, book.ID)
+	testReq(t, "Nested(PATCH pointer key)", "PATCH", item, `{"name":"Renamed"}`, 200)
+	testReq(t, "Nested(PATCH move pointer key)", "PATCH", item, fmt.Sprintf(`{"library_id":%d}`, other.ID), 422)
+	testReq(t, "Nested(PATCH clear pointer key)", "PATCH", item, `{"library_id":null}`, 422)
+
+	testReq(t, "Nested(POST sql.Null key)", "POST", fmt.Sprintf("/api/libraries/%d/library_labels", library.ID), `{"name":"Label"}`, 201)
+	label := LibraryLabel{}
+	db.Where("name = ?", "Label").Last(&label)
+	if !label.LibraryID.Valid || label.LibraryID.Int64 != int64(library.ID) {
+		t.Errorf("POST didn't set the sql.NullInt64 foreign key: %+v", label)
+	}
+	testReq(t, "Nested(PATCH sql.Null key)", "PATCH", fmt.Sprintf("/api/libraries/%d/library_labels/%d", library.ID, label.ID), `{"name":"Relabelled"}`, 200)
+	db.Delete(&label)
+	db.Delete(&LibraryBook{ID: book.ID})
+}
@@ -0,0 +1,199 @@
+package grapi
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	for _, p := range []string{"limit", "offset", "page", "per_page"} {
+		reservedParams[p] = true
+	}
+}
+
+// pageInfo describes the page of results being returned by an index route.
+type pageInfo struct {
+	limit     int
+	offset    int
+	total     int
+	pageStyle bool // true if the client asked for ?page=&per_page= rather than ?limit=&offset=
+
+	cursor     bool   // true if this is a cursor paginated route
+	nextCursor string // the cursor for the next page, or "" if this is the last page
+}
+
+// pageSizes returns the default and maximum page size for the route, taking
+// RouteOptions in preference to Options.
+func (r *request) pageSizes() (int, int) {
+	def, max := r.options.DefaultPageSize, r.options.MaxPageSize
+	if def == 0 {
+		def = r.api.options.DefaultPageSize
+	}
+	if max == 0 {
+		max = r.api.options.MaxPageSize
+	}
+	return def, max
+}
+
+// intParam returns the query parameter k as a non-negative integer, or 0 if it
+// is not present.
+func (r *request) intParam(k string) (int, error) {
+	v := r.R.URL.Query().Get(k)
+	if v == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", k)
+	}
+	return i, nil
+}
+
+// parsePage reads the limit/offset or page/per_page query parameters.
+func (r *request) parsePage() (*pageInfo, error) {
+	q := r.R.URL.Query()
+	p := &pageInfo{}
+	page := 1
+	var err error
+	if q.Get("page") != "" || q.Get("per_page") != "" {
+		if q.Get("limit") != "" || q.Get("offset") != "" {
+			return nil, fmt.Errorf("Use either page and per_page, or limit and offset")
+		}
+		p.pageStyle = true
+		if q.Get("page") != "" {
+			if page, err = r.intParam("page"); err != nil || page < 1 {
+				return nil, fmt.Errorf("page must be a positive integer")
+			}
+		}
+		if p.limit, err = r.intParam("per_page"); err != nil {
+			return nil, err
+		}
+	} else {
+		if p.limit, err = r.intParam("limit"); err != nil {
+			return nil, err
+		}
+		if p.offset, err = r.intParam("offset"); err != nil {
+			return nil, err
+		}
+	}
+	def, max := r.pageSizes()
+	if p.limit == 0 {
+		p.limit = def
+	}
+	if max > 0 && (p.limit == 0 || p.limit > max) {
+		p.limit = max
+	}
+	if p.pageStyle {
+		if p.limit == 0 {
+			return nil, fmt.Errorf("per_page must be given")
+		}
+		p.offset = (page - 1) * p.limit
+	}
+	if p.offset > 0 && p.limit == 0 {
+		// Not all databases support OFFSET without LIMIT
+		return nil, fmt.Errorf("limit must be given with offset")
+	}
+	return p, nil
+}
+
+// Paginate counts the items matched by r.DB, and then limits r.DB to a single
+// page of them. If the client gave no page parameters and there is no default
+// or maximum page size then all items are returned as before.
+func (r *request) Paginate() bool {
+	if r.options.CursorPagination {
+		return r.cursorPaginate()
+	}
+	p, err := r.parsePage()
+	if err != nil {
+		return r.badRequest(err)
+	}
+	if p.limit == 0 {
+		return true
+	}
+	model := reflect.New(r.Type.Elem()).Interface()
+	if err := r.DB.Model(model).Count(&p.total).Error; err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Can't count items")
+		return r.problem(500, "Database error")
+	}
+	r.DB = r.DB.Limit(p.limit).Offset(p.offset)
+	r.page = p
+	return true
+}
+
+// AddPaginationMeta sets the X-Total-Count and Link headers for a paginated
+// response, and wraps the result in an envelope if RouteOptions.PaginationEnvelope
+// is set.
+func (r *request) AddPaginationMeta() bool {
+	p := r.page
+	if p == nil {
+		return true
+	}
+	meta := map[string]interface{}{"limit": p.limit}
+	if p.cursor {
+		if p.nextCursor != "" {
+			r.W.Header().Set("X-Next-Cursor", p.nextCursor)
+			r.W.Header().Set("Link", r.cursorLink(p.nextCursor))
+		}
+		meta["next_cursor"] = p.nextCursor
+	} else {
+		r.W.Header().Set("X-Total-Count", strconv.Itoa(p.total))
+		r.W.Header().Set("Link", r.linkHeader(p))
+		meta["total"] = p.total
+		meta["offset"] = p.offset
+	}
+	if r.options.PaginationEnvelope {
+		r.Result = map[string]interface{}{"data": r.Result, "meta": meta}
+	}
+	return true
+}
+
+// linkHeader builds an RFC 5988 Link header with first, prev, next and last
+// links for the page p.
+func (r *request) linkHeader(p *pageInfo) string {
+	last := 0
+	if p.total > 0 {
+		last = ((p.total - 1) / p.limit) * p.limit
+	}
+	links := []string{r.pageLink(p, 0, "first")}
+	if p.offset > 0 {
+		prev := p.offset - p.limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, r.pageLink(p, prev, "prev"))
+	}
+	if p.offset+p.limit < p.total {
+		links = append(links, r.pageLink(p, p.offset+p.limit, "next"))
+	}
+	links = append(links, r.pageLink(p, last, "last"))
+	return strings.Join(links, ", ")
+}
+
+// pageLink returns a single link for linkHeader, pointing at the page starting
+// at offset.
+func (r *request) pageLink(p *pageInfo, offset int, rel string) string {
+	q := r.R.URL.Query()
+	if p.pageStyle {
+		q.Set("page", strconv.Itoa(offset/p.limit+1))
+		q.Set("per_page", strconv.Itoa(p.limit))
+	} else {
+		q.Set("limit", strconv.Itoa(p.limit))
+		q.Set("offset", strconv.Itoa(offset))
+	}
+	u := url.URL{Path: r.R.URL.Path, RawQuery: q.Encode()}
+	return fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel)
+}
+
+// cursorLink returns the Link header pointing at the next page of a cursor
+// paginated route.
+func (r *request) cursorLink(cursor string) string {
+	q := r.R.URL.Query()
+	q.Set("cursor", cursor)
+	u := url.URL{Path: r.R.URL.Path, RawQuery: q.Encode()}
+	return fmt.Sprintf(`<%s>; rel="next"`, u.String())
+}
@@ -0,0 +1,116 @@
+package grapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Check limit/offset and page/per_page, and the headers that describe the page.
+func TestPagination(t *testing.T) {
+	resp := testReqWithHeaders(t, "Paginate(limit)", "GET", "/api/widgets?id[lte]=3&limit=2", "", nil, 200)
+	result := make([]Widget, 0)
+	json.Unmarshal(resp.Body.Bytes(), &result)
+	if len(result) != 2 || result[0].ID != 1 {
+		t.Errorf("Didn't get first page of two widgets: %v", result)
+	}
+	if total := resp.Header().Get("X-Total-Count"); total != "3" {
+		t.Errorf("Expected X-Total-Count of 3, got %q", total)
+	}
+	link := resp.Header().Get("Link")
+	if !strings.Contains(link, `offset=2>; rel="next"`) || strings.Contains(link, `rel="prev"`) {
+		t.Errorf("Bad Link header for first page: %s", link)
+	}
+
+	resp = testReqWithHeaders(t, "Paginate(page)", "GET", "/api/widgets?id[lte]=3&page=2&per_page=2", "", nil, 200)
+	json.Unmarshal(resp.Body.Bytes(), &result)
+	if len(result) != 1 || result[0].ID != 3 {
+		t.Errorf("Didn't get second page of widgets: %v", result)
+	}
+	link = resp.Header().Get("Link")
+	if !strings.Contains(link, `page=1&per_page=2>; rel="prev"`) || strings.Contains(link, `rel="next"`) {
+		t.Errorf("Bad Link header for last page: %s", link)
+	}
+
+	testReq(t, "Paginate(offset without limit)", "GET", "/api/widgets?offset=1", "", 400)
+	testReq(t, "Paginate(bad limit)", "GET", "/api/widgets?limit=-1", "", 400)
+	testReq(t, "Paginate(bad page)", "GET", "/api/widgets?page=0&per_page=2", "", 400)
+	testReq(t, "Paginate(mixed)", "GET", "/api/widgets?page=1&limit=2", "", 400)
+}
+
+// Check default and maximum page sizes, and the envelope.
+func TestPaginationOptions(t *testing.T) {
+	api := getTestApi()
+	api.AddIndexRoute(&Widget{}, &RouteOptions{UriModelName: "paged_widgets", DefaultPageSize: 1, MaxPageSize: 2, PaginationEnvelope: true})
+
+	var envelope struct {
+		Data []Widget
+		Meta map[string]int
+	}
+	body := testReq(t, "Paginate(default)", "GET", "/api/paged_widgets?id[lte]=3", "", 200)
+	json.Unmarshal([]byte(body), &envelope)
+	if len(envelope.Data) != 1 || envelope.Meta["total"] != 3 || envelope.Meta["limit"] != 1 {
+		t.Errorf("Bad envelope for default page size: %s", body)
+	}
+	body = testReq(t, "Paginate(max)", "GET", "/api/paged_widgets?id[lte]=3&limit=100", "", 200)
+	json.Unmarshal([]byte(body), &envelope)
+	if len(envelope.Data) != 2 || envelope.Meta["limit"] != 2 {
+		t.Errorf("Page size not limited to maximum: %s", body)
+	}
+}
+
+// Page through widgets with a cursor, in both directions.
+func TestCursorPagination(t *testing.T) {
+	api := getTestApi()
+	api.AddIndexRoute(&Widget{}, &RouteOptions{UriModelName: "cursor_widgets", CursorPagination: true})
+	api.AddIndexRoute(&Widget{}, &RouteOptions{UriModelName: "reverse_cursor_widgets", CursorPagination: true, CursorField: "-name"})
+
+	for _, test := range []struct {
+		uri      string
+		expected []uint
+	}{
+		{"/api/cursor_widgets?id[lte]=3&limit=2", []uint{1, 2, 3}},
+		{"/api/reverse_cursor_widgets?id[lte]=3&limit=2", []uint{3, 2, 1}},
+	} {
+		ids := make([]uint, 0)
+		uri := test.uri
+		for pages := 0; uri != "" && pages < 5; pages++ {
+			resp := testReqWithHeaders(t, "CursorPage", "GET", uri, "", nil, 200)
+			result := make([]Widget, 0)
+			json.Unmarshal(resp.Body.Bytes(), &result)
+			for _, w := range result {
+				ids = append(ids, w.ID)
+			}
+			uri = ""
+			if cursor := resp.Header().Get("X-Next-Cursor"); cursor != "" {
+				uri = test.uri + "&cursor=" + cursor
+			}
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.expected) {
+			t.Errorf("Paging through %s gave %v, expected %v", test.uri, ids, test.expected)
+		}
+	}
+
+	testReq(t, "Cursor(tampered)", "GET", "/api/cursor_widgets?limit=2&cursor=eyJ2IjoiMSIsImlkIjoiMSJ9.AAAA", "", 400)
+	testReq(t, "Cursor(offset)", "GET", "/api/cursor_widgets?limit=2&offset=2", "", 400)
+	testReq(t, "Cursor(no limit)", "GET", "/api/cursor_widgets", "", 400)
+}
+
+// A model whose rank may be NULL
+type RankedWidget struct {
+	ID    uint          `gorm:"primary_key" json:"id"`
+	Rank  *int          `json:"rank"`
+	Score sql.NullInt64 `json:"score"`
+}
+
+func TestCursorNullableField(t *testing.T) {
+	api := getTestApi()
+	for _, field := range []string{"rank", "-score"} {
+		func() {
+			defer ensurePanic(t, "Cursor pagination by a field that can be NULL")
+			api.AddIndexRoute(&RankedWidget{}, &RouteOptions{UriModelName: "ranked_widgets_" + field, CursorPagination: true, CursorField: field})
+		}()
+	}
+}
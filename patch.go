@@ -0,0 +1,223 @@
+package grapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Content types which PATCH treats specially. Anything else is unmarshalled
+// straight over the existing item.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// errPatchTestFailed is returned when a json patch "test" operation fails. It
+// is reported as a 409 Conflict rather than a 422.
+var errPatchTestFailed = errors.New("Patch test failed")
+
+// jsonPatchOp is a single operation of an RFC 6902 json patch.
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// applyPatch applies the uploaded body to r.Result according to the request's
+// Content-Type. An application/merge-patch+json body is merged as in RFC 7396,
+// so a null removes a field (resetting it to its zero value). An
+// application/json-patch+json body is a list of RFC 6902 add, remove, replace
+// and test operations. Both are applied to the json form of the item. Any other
+// body is unmarshalled over the item as before.
+func (r *request) applyPatch(body []byte) error {
+	contentType, _, _ := mime.ParseMediaType(r.R.Header.Get("Content-Type"))
+	if contentType != mergePatchType && contentType != jsonPatchType {
+		return json.Unmarshal(body, r.Result)
+	}
+	doc, err := decodeJSON(r.Result)
+	if err != nil {
+		return err
+	}
+	if contentType == mergePatchType {
+		patch, err := decodeJSON(json.RawMessage(body))
+		if err != nil {
+			return err
+		}
+		doc = mergePatch(doc, patch)
+	} else {
+		ops := make([]jsonPatchOp, 0)
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return err
+		}
+		for _, op := range ops {
+			if doc, err = op.apply(doc); err != nil {
+				return err
+			}
+		}
+	}
+	return r.setResultFromJSON(doc)
+}
+
+// setResultFromJSON unmarshals the patched json document into r.Result. Fields
+// which are missing or null in the document are reset to their zero value, as
+// json.Unmarshal would otherwise leave them alone.
+func (r *request) setResultFromJSON(doc interface{}) error {
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Patched item must be a json object")
+	}
+	item := reflect.ValueOf(r.Result).Elem()
+	for _, f := range r.api.getModelFields(r.Type) {
+		if v, ok := obj[f.JSONName]; !ok || v == nil {
+			field := item.FieldByName(f.Name)
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+	j, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, r.Result)
+}
+
+// decodeJSON returns the generic json form (maps, slices, json.Numbers etc.) of v.
+func decodeJSON(v interface{}) (interface{}, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(j))
+	decoder.UseNumber()
+	err = decoder.Decode(&doc)
+	return doc, err
+}
+
+// mergePatch applies patch to target as described in RFC 7396.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// apply applies a single json patch operation to doc, returning the new document.
+func (op *jsonPatchOp) apply(doc interface{}) (interface{}, error) {
+	if op.Path != "" && !strings.HasPrefix(op.Path, "/") {
+		return nil, fmt.Errorf("Bad json pointer %q", op.Path)
+	}
+	tokens := make([]string, 0)
+	if op.Path != "" {
+		for _, t := range strings.Split(op.Path[1:], "/") {
+			tokens = append(tokens, strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1))
+		}
+	}
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, fmt.Errorf("%s operation needs a value", op.Op)
+		}
+		v, err := decodeJSON(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		value = v
+	case "remove":
+	default:
+		return nil, fmt.Errorf("Unsupported patch operation %q", op.Op)
+	}
+	return op.applyAt(doc, tokens, value)
+}
+
+// applyAt walks down doc following the json pointer tokens, and carries out the
+// operation at the end of the path.
+func (op *jsonPatchOp) applyAt(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		switch op.Op {
+		case "remove":
+			return nil, fmt.Errorf("Can't remove the whole item")
+		case "test":
+			if !jsonEqual(doc, value) {
+				return nil, errPatchTestFailed
+			}
+			return doc, nil
+		}
+		return value, nil
+	}
+	key, rest := tokens[0], tokens[1:]
+	notFound := fmt.Errorf("Path %q not found", op.Path)
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		child, ok := d[key]
+		if len(rest) == 0 && op.Op == "add" {
+			d[key] = value
+			return d, nil
+		}
+		if !ok {
+			return nil, notFound
+		}
+		if len(rest) == 0 && op.Op == "remove" {
+			delete(d, key)
+			return d, nil
+		}
+		child, err := op.applyAt(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		d[key] = child
+		return d, nil
+	case []interface{}:
+		if len(rest) == 0 && op.Op == "add" && key == "-" {
+			return append(d, value), nil
+		}
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i > len(d) || (i == len(d) && !(len(rest) == 0 && op.Op == "add")) {
+			return nil, notFound
+		}
+		if len(rest) == 0 && op.Op == "add" {
+			d = append(d, nil)
+			copy(d[i+1:], d[i:])
+			d[i] = value
+			return d, nil
+		}
+		if len(rest) == 0 && op.Op == "remove" {
+			return append(d[:i], d[i+1:]...), nil
+		}
+		child, err := op.applyAt(d[i], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		d[i] = child
+		return d, nil
+	}
+	return nil, notFound
+}
+
+// jsonEqual compares two generic json values, treating numbers by value.
+func jsonEqual(a interface{}, b interface{}) bool {
+	var av, bv interface{}
+	aj, _ := json.Marshal(a)
+	bj, _ := json.Marshal(b)
+	json.Unmarshal(aj, &av)
+	json.Unmarshal(bj, &bv)
+	return reflect.DeepEqual(av, bv)
+}
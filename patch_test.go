@@ -0,0 +1,68 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	widget := PrivateWidget{UserID: 3, Name: "ToMerge"}
+	getTestApi().DB().Create(&widget)
+	uri := fmt.Sprintf("/api/widgets_for_patch/%d", widget.ID)
+	getTestApi().AddPatchRoute(&PrivateWidget{}, &RouteOptions{UriModelName: "widgets_for_patch"})
+	headers := map[string]string{"Content-Type": "application/merge-patch+json"}
+
+	rec := testReqWithHeaders(t, "MergePatch", "PATCH", uri, `{"name":null,"user_id":4}`, headers, 200)
+	check := PrivateWidget{}
+	getTestApi().DB().Where("id = ?", widget.ID).Find(&check)
+	if check.Name != "" || check.UserID != 4 {
+		t.Errorf("Merge patch didn't null name and set user_id: %v %s", check, rec.Body.String())
+	}
+	testReqWithHeaders(t, "MergePatch(RemoveID)", "PATCH", uri, `{"id":null}`, headers, 422)
+	testReqWithHeaders(t, "MergePatch(Malformed)", "PATCH", uri, `{"name"`, headers, 422)
+}
+
+func TestJSONPatch(t *testing.T) {
+	widget := PrivateWidget{UserID: 3, Name: "ToPatch"}
+	getTestApi().DB().Create(&widget)
+	uri := fmt.Sprintf("/api/widgets_for_json_patch/%d", widget.ID)
+	getTestApi().AddPatchRoute(&PrivateWidget{}, &RouteOptions{UriModelName: "widgets_for_json_patch"})
+	headers := map[string]string{"Content-Type": "application/json-patch+json"}
+
+	body := `[{"op":"test","path":"/name","value":"ToPatch"},{"op":"replace","path":"/name","value":"Patched"},{"op":"remove","path":"/user_id"}]`
+	rec := testReqWithHeaders(t, "JSONPatch", "PATCH", uri, body, headers, 200)
+	result := PrivateWidget{}
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.Name != "Patched" || result.UserID != 0 {
+		t.Errorf("Json patch wasn't applied: %s", rec.Body.String())
+	}
+	testReqWithHeaders(t, "JSONPatch(TestFails)", "PATCH", uri, `[{"op":"test","path":"/name","value":"ToPatch"}]`, headers, 409)
+	testReqWithHeaders(t, "JSONPatch(MissingPath)", "PATCH", uri, `[{"op":"replace","path":"/nothing/here","value":1}]`, headers, 422)
+	testReqWithHeaders(t, "JSONPatch(BadOp)", "PATCH", uri, `[{"op":"move","from":"/name","path":"/other"}]`, headers, 422)
+	testReqWithHeaders(t, "JSONPatch(ChangeID)", "PATCH", uri, `[{"op":"replace","path":"/id","value":99999}]`, headers, 422)
+	testReqWithHeaders(t, "JSONPatch(NotAList)", "PATCH", uri, `{"op":"remove","path":"/name"}`, headers, 422)
+}
+
+func TestJSONPatchOperations(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{"a":[1,2,3],"b":{"c~/d":true}}`), &doc)
+	ops := make([]jsonPatchOp, 0)
+	json.Unmarshal([]byte(`[{"op":"add","path":"/a/1","value":9},{"op":"add","path":"/a/-","value":4},`+
+		`{"op":"remove","path":"/a/0"},{"op":"replace","path":"/b/c~0~1d","value":false},{"op":"test","path":"/a","value":[9,2,3,4]}]`), &ops)
+	var err error
+	for _, op := range ops {
+		if doc, err = op.apply(doc); err != nil {
+			t.Fatalf("Failed to apply %v: %v", op, err)
+		}
+	}
+	expected := map[string]interface{}{"a": []interface{}{9, 2, 3, 4}, "b": map[string]interface{}{"c~/d": false}}
+	if !jsonEqual(doc, expected) {
+		t.Errorf("Json patch gave %v, expected %v", doc, expected)
+	}
+	merged := mergePatch(map[string]interface{}{"a": "b", "c": map[string]interface{}{"d": "e", "f": "g"}},
+		map[string]interface{}{"a": "z", "c": map[string]interface{}{"f": nil}})
+	if !jsonEqual(merged, map[string]interface{}{"a": "z", "c": map[string]interface{}{"d": "e"}}) {
+		t.Errorf("Merge patch gave %v", merged)
+	}
+}
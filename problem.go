@@ -0,0 +1,138 @@
+package grapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+	"github.com/zenazn/goji/web/middleware"
+)
+
+// Problem describes an error returned to the client. By default it is sent as
+// an RFC 7807 problem details object with Content-Type application/problem+json,
+// eg.
+//   {"type":"about:blank","title":"Unprocessable Entity","status":422,
+//    "detail":"Validation failed","request_id":"host/abc-000001",
+//    "errors":{"name":"Is required"}}
+// Set Options.ProblemWriter to send errors in another format.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Code      string            `json:"code,omitempty"` // An optional machine readable error code
+	RequestID string            `json:"request_id,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"` // Field level errors, keyed by json field name
+	Items     map[int]*Problem  `json:"items,omitempty"`  // For bulk requests, the errors of each failed item, keyed by position
+}
+
+// ProblemWriter writes a Problem to the client. See Options.ProblemWriter
+type ProblemWriter func(w http.ResponseWriter, r *http.Request, p *Problem)
+
+// NewProblem returns a Problem with the given http status, and a title taken
+// from the status.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// NewError returns a Problem for a callback to reject a request with using
+// req.Fail(), eg.
+//   return req.Fail(grapi.NewError(403, "admin_only", "You need to be admin to do that", nil))
+// details are optional field level errors.
+func NewError(status int, code string, message string, details map[string]string) *Problem {
+	p := NewProblem(status, message)
+	p.Code = code
+	p.Errors = details
+	return p
+}
+
+// Error returns the detail of the problem, so that a Problem is an error.
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+	return p.Detail
+}
+
+// writeProblemJSON is the default ProblemWriter, and writes p as application/problem+json
+func writeProblemJSON(w http.ResponseWriter, r *http.Request, p *Problem) {
+	j, err := json.Marshal(p)
+	if err != nil {
+		log.Errorf("Can't encode problem %v: %v", p, err)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(j)
+}
+
+// writeProblem adds the request id to p, and writes it using Options.ProblemWriter
+func (g *Grapi) writeProblem(c web.C, w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.RequestID == "" {
+		p.RequestID = middleware.GetReqID(c)
+	}
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	for _, item := range p.Items {
+		if item.Type == "" {
+			item.Type = "about:blank"
+		}
+		if item.Title == "" {
+			item.Title = http.StatusText(item.Status)
+		}
+	}
+	if g.options.ProblemWriter != nil {
+		g.options.ProblemWriter(w, r, p)
+	} else {
+		writeProblemJSON(w, r, p)
+	}
+}
+
+// problem writes a Problem with the given status and detail to the client. It
+// returns false so that handlers can simply return r.problem(...) to stop the
+// request.
+func (r *request) problem(status int, detail string) bool {
+	return r.writeProblem(NewProblem(status, detail))
+}
+
+// writeProblem writes p to the client, and returns false. For an item of a bulk
+// request p is instead kept as the item's failure, to be reported with the others.
+func (r *request) writeProblem(p *Problem) bool {
+	if r.inBatch {
+		r.failure = p
+		return false
+	}
+	r.api.writeProblem(r.C, r.W, r.R, p)
+	return false
+}
+
+// Fail records err as the reason the request failed, and returns false so that
+// callbacks can simply
+//   return req.Fail(err)
+// The handler then sends err to the client once the callback has returned. If err
+// is a *Problem (eg. from NewError) it is sent as it is. Any other error is logged,
+// and sent as a 500 Internal Server Error without its message.
+func (r *request) Fail(err error) bool {
+	r.failure = err
+	return false
+}
+
+// writeFailure is called by the handlers when the request has been stopped. It
+// sends the error given to Fail, if there is one. Otherwise whatever stopped the
+// request has already written a response.
+func (r *request) writeFailure() {
+	if r.failure == nil {
+		return
+	}
+	p, ok := r.failure.(*Problem)
+	if !ok {
+		p = NewProblem(500, "")
+	}
+	log.WithFields(log.Fields{"error": r.failure, "status": p.Status, "code": p.Code,
+		"request_id": middleware.GetReqID(r.C), "method": r.method, "path": r.R.URL.Path}).Warn("Request failed")
+	r.writeProblem(p)
+}
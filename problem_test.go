@@ -0,0 +1,78 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProblemResponses(t *testing.T) {
+	for _, c := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"GET", "/api/widgets/4242", "", 404},
+		{"POST", "/api/widgets", `{"name"`, 422},
+		{"GET", "/api/private_widgets", "", 401},
+		{"GET", "/api/widgets?colour=red", "", 400},
+		{"POST", "/api/auth", `{"name":"admin","password":"wrong"}`, 403},
+	} {
+		rec := testReqWithHeaders(t, "Problem", c.method, c.path, c.body, nil, c.code)
+		problem := Problem{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+			t.Errorf("%s %s didn't return json: %q", c.method, c.path, rec.Body.String())
+		}
+		if rec.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s %s returned Content-Type %q", c.method, c.path, rec.Header().Get("Content-Type"))
+		}
+		if problem.Status != rec.Code || problem.Title != http.StatusText(rec.Code) || problem.Type != "about:blank" || problem.RequestID == "" {
+			t.Errorf("%s %s returned a bad problem: %s", c.method, c.path, rec.Body.String())
+		}
+	}
+}
+
+func TestProblemWriter(t *testing.T) {
+	api := New(Options{Db: getTestDb(), UriPrefix: "problem_api",
+		ProblemWriter: func(w http.ResponseWriter, r *http.Request, p *Problem) {
+			w.WriteHeader(p.Status)
+			fmt.Fprintf(w, "%d %s", p.Status, p.Error())
+		}})
+	api.AddDefaultRoutes(&Widget{})
+	req, _ := http.NewRequest("GET", "/problem_api/widgets/4242", nil)
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != 404 || rec.Body.String() != "404 Not Found" {
+		t.Errorf("Custom ProblemWriter wasn't used: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestFail(t *testing.T) {
+	api := getTestApi()
+	api.AddDefaultRoutes(&Widget{}, RouteOptions{
+		UriModelName: "failing_widgets",
+		Authorize: func(req ReqToAuthorize) bool {
+			if req.Method() == "DELETE" {
+				return req.Fail(fmt.Errorf("Secret internal error"))
+			}
+			if req.Method() != "GET" {
+				return req.Fail(NewError(403, "read_only", "Widgets are read only", map[string]string{"name": "Can't be changed"}))
+			}
+			return true
+		}})
+	testReq(t, "Fail(GET)", "GET", "/api/failing_widgets/1", "", 200)
+	body := testReq(t, "Fail(PATCH)", "PATCH", "/api/failing_widgets/1", `{"name":"Changed"}`, 403)
+	problem := Problem{}
+	json.Unmarshal([]byte(body), &problem)
+	if problem.Status != 403 || problem.Code != "read_only" || problem.Detail != "Widgets are read only" ||
+		problem.Errors["name"] != "Can't be changed" || problem.RequestID == "" {
+		t.Errorf("Didn't get the callback's error: %s", body)
+	}
+	body = testReq(t, "Fail(DELETE)", "DELETE", "/api/failing_widgets/1", "", 500)
+	problem = Problem{}
+	json.Unmarshal([]byte(body), &problem)
+	if problem.Status != 500 || problem.Detail != "" {
+		t.Errorf("Plain error wasn't hidden behind a 500: %s", body)
+	}
+}
@@ -0,0 +1,109 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// GetItemForPut is GetItemById for PUT requests. If the item doesn't exist and
+// RouteOptions.AllowCreateOnPut is set then it carries on so that the item will
+// be created with the id in the url.
+func (r *request) GetItemForPut() bool {
+	if !r.options.AllowCreateOnPut {
+		return r.GetItemById()
+	}
+	item := reflect.New(r.Type).Interface()
+	qstring := fmt.Sprintf("%s.id = ?", r.TableName)
+	if r.DB.Where(qstring, r.Param("id")).Find(item).RecordNotFound() {
+		r.creating = true
+		return true
+	}
+	r.Result = item
+	return true
+}
+
+// ReplaceResultWithUploaded unmarshals the uploaded json into a new item, which
+// replaces the existing item entirely. Fields missing from the json are reset to
+// their zero value, unless they are listed in RouteOptions.RequiredFields in which
+// case a 422 is returned. The id is taken from the url, and can't be changed.
+// Fields the client can't send (see keepUnsentFields) are kept.
+func (r *request) ReplaceResultWithUploaded() bool {
+	body := httpBody(r.R)
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		log.WithFields(log.Fields{"error": err}).Warn("Can't parse incoming json")
+		return r.problem(422, "Can't parse json: "+err.Error()) // unprocessable entity
+	}
+	missing := make(map[string]string)
+	for _, name := range r.options.RequiredFields {
+		if fields[name] == nil {
+			missing[name] = "Is required"
+		}
+	}
+	if len(missing) > 0 {
+		log.WithFields(log.Fields{"error": missing}).Warn("Validation error")
+		p := NewProblem(422, "Validation failed")
+		p.Errors = missing
+		return r.writeProblem(p)
+	}
+	item := reflect.New(r.Type).Interface()
+	if err := json.Unmarshal(body, item); err != nil {
+		log.WithFields(log.Fields{"error": err}).Warn("Can't parse incoming json")
+		return r.problem(422, "Can't parse json: "+err.Error()) // unprocessable entity
+	}
+	id, err := getID(item)
+	if err != nil || id != reflect.Zero(reflect.TypeOf(id)).Interface() && fmt.Sprint(id) != r.Param("id") {
+		log.WithFields(log.Fields{"uploadedID": id, "id": r.Param("id")}).Warn("Put trying to change ID")
+		return r.problem(422, "Can't change the id") // unprocessable entity
+	}
+	if err := setID(item, r.Param("id")); err != nil {
+		log.WithFields(log.Fields{"error": err}).Warn("Bad ID for PUT")
+		return r.problem(404, "")
+	}
+	if !r.creating {
+		r.keepUnsentFields(item)
+	}
+	r.Uploaded = item
+	r.Result = item
+	return r.validateUpload()
+}
+
+// keepUnsentFields copies the columns of the existing item that a client can't
+// set into its replacement. These are columns hidden from json with `json:"-"`
+// (eg. a password hash), and CreatedAt and DeletedAt which gorm manages.
+func (r *request) keepUnsentFields(item interface{}) {
+	visible := make(map[string]bool)
+	for _, f := range r.columns {
+		visible[f.Name] = f.Name != "CreatedAt" && f.Name != "DeletedAt"
+	}
+	existing := r.DB.NewScope(r.Result)
+	for _, f := range r.DB.NewScope(item).Fields() {
+		if !f.IsNormal || visible[f.Name] {
+			continue
+		}
+		if old, ok := existing.FieldByName(f.Name); ok {
+			f.Field.Set(old.Field)
+		}
+	}
+}
+
+// PutDB saves the object in r.Uploaded to the db, overwriting every field of an
+// existing item, or creating it if GetItemForPut didn't find one.
+func (r *request) PutDB() bool {
+	var err error
+	if r.creating {
+		err = r.writeDB().Create(r.Uploaded).Error
+	} else if r.options.VersionColumn != "" {
+		return r.versionedUpdate()
+	} else {
+		err = r.writeDB().Save(r.Uploaded).Error
+	}
+	if err != nil {
+		return r.dbError(err)
+	}
+	r.Result = r.Uploaded
+	return true
+}
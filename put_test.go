@@ -0,0 +1,83 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// PutAccount has columns a client can't send, which PUT mustn't reset.
+type PutAccount struct {
+	ID        uint
+	Name      string
+	Password  string `json:"-"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func TestPutHandlers(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	api.AddDefaultRoutes(&PrivateWidget{}, RouteOptions{UriModelName: "put_widgets", RequiredFields: []string{"name"}})
+	api.AddPutRoute(&PrivateWidget{}, &RouteOptions{UriModelName: "upsert_widgets", AllowCreateOnPut: true})
+
+	widget := PrivateWidget{UserID: 7, Name: "ToReplace"}
+	db.Create(&widget)
+	uri := fmt.Sprintf("/api/put_widgets/%d", widget.ID)
+
+	testReq(t, "Put(Doesn'tExist)", "PUT", "/api/put_widgets/9999", `{"name":"New"}`, 404)
+	testReq(t, "Put(MalformedJson)", "PUT", uri, `{"name:Replaced"}`, 422)
+	testReq(t, "Put(EditID)", "PUT", uri, fmt.Sprintf(`{"id":%d,"name":"Replaced"}`, widget.ID+1), 422)
+	body := testReq(t, "Put(Required)", "PUT", uri, `{"user_id":7}`, 422)
+	problem := Problem{}
+	json.Unmarshal([]byte(body), &problem)
+	if problem.Errors["name"] != "Is required" {
+		t.Errorf("Didn't receive correct error for missing required field: %s", body)
+	}
+
+	body = testReq(t, "Put", "PUT", uri, `{"name":"Replaced"}`, 200)
+	result := PrivateWidget{}
+	json.Unmarshal([]byte(body), &result)
+	if result.ID != widget.ID || result.Name != "Replaced" || result.UserID != 0 {
+		t.Errorf("PUT didn't replace the item: %s", body)
+	}
+	check := PrivateWidget{}
+	db.Where("id = ?", widget.ID).Find(&check)
+	if check.Name != "Replaced" || check.UserID != 0 {
+		t.Errorf("PUT didn't reset missing fields in the db: %v", check)
+	}
+	testReq(t, "Put(SameID)", "PUT", uri, fmt.Sprintf(`{"id":%d,"name":"Replaced"}`, widget.ID), 200)
+
+	testReqWithHeaders(t, "Put(Create with If-Match)", "PUT", "/api/upsert_widgets/4242", `{"name":"Created"}`, map[string]string{"If-Match": "*"}, 412)
+	testReq(t, "Put(CreateNotAllowed)", "PUT", "/api/put_widgets/4242", `{"name":"Created"}`, 404)
+	body = testReq(t, "Put(Create)", "PUT", "/api/upsert_widgets/4242", `{"name":"Created"}`, 201)
+	check = PrivateWidget{}
+	if db.Where("id = ?", 4242).Find(&check).RecordNotFound() || check.Name != "Created" {
+		t.Errorf("PUT didn't create the item with the id in the url: %s", body)
+	}
+	testReq(t, "Put(Upsert)", "PUT", "/api/upsert_widgets/4242", `{"name":"Upserted"}`, 200)
+	db.Where("id = ?", 4242).Find(&check)
+	if check.Name != "Upserted" {
+		t.Errorf("PUT didn't replace the existing item: %v", check)
+	}
+	testReq(t, "Put(BadID)", "PUT", "/api/upsert_widgets/abc", `{"name":"Created"}`, 404)
+	db.Delete(&check)
+}
+
+func TestPutKeepsUnsentFields(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	db.AutoMigrate(&PutAccount{})
+	api.AddPutRoute(&PutAccount{}, &RouteOptions{})
+
+	account := PutAccount{Name: "a", Password: "hash"}
+	db.Create(&account)
+	defer db.Delete(&account)
+	testReq(t, "Put(Unsent fields)", "PUT", fmt.Sprintf("/api/put_accounts/%d", account.ID), `{"name":"b","CreatedAt":"2001-01-01T00:00:00Z"}`, 200)
+	check := PutAccount{}
+	db.Where("id = ?", account.ID).Find(&check)
+	if check.Name != "b" || check.Password != "hash" || !check.CreatedAt.Equal(account.CreatedAt) {
+		t.Errorf("PUT should only replace the fields a client can send: %+v (was %+v)", check, account)
+	}
+}
@@ -0,0 +1,194 @@
+package grapi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/zenazn/goji/web"
+)
+
+// RefreshToken is a refresh token issued by the login route. Each login starts a
+// new family of refresh tokens, and each refresh uses up a token and replaces it
+// with a new one in the same family. If a used token is presented again it has
+// probably been stolen, so the whole family is revoked.
+type RefreshToken struct {
+	Hash      string `gorm:"primary_key"` // A sha256 hash of the token. The token itself isn't stored.
+	Family    string `sql:"index"`
+	Subject   string `sql:"index"` // The user the token was issued to
+	ExpiresAt time.Time
+	Used      bool
+	Revoked   bool
+}
+
+// TableName keeps grapi's table out of the way of the application's own.
+func (RefreshToken) TableName() string {
+	return "grapi_refresh_tokens"
+}
+
+// RefreshTokenStore keeps track of the refresh tokens that have been issued. Set
+// Options.RefreshTokenStore to keep them somewhere other than the database.
+type RefreshTokenStore interface {
+	// Save stores a newly issued token.
+	Save(t *RefreshToken) error
+	// Find returns the token with the given hash, or nil if there isn't one.
+	Find(hash string) (*RefreshToken, error)
+	// Use marks a token as used, and returns false if it had already been used. It
+	// must be atomic, so that a token can't be used twice by concurrent requests.
+	Use(hash string) (bool, error)
+	// RevokeFamily revokes every token in a family.
+	RevokeFamily(family string) error
+	// RevokeSubject revokes every token issued to the user with this subject.
+	RevokeSubject(sub string) error
+}
+
+// dbRefreshTokenStore is the default RefreshTokenStore, which keeps tokens in
+// the grapi_refresh_tokens table.
+type dbRefreshTokenStore struct {
+	db *gorm.DB
+}
+
+// newDBRefreshTokenStore returns a RefreshTokenStore using db, creating its
+// table if needed.
+func newDBRefreshTokenStore(db *gorm.DB) *dbRefreshTokenStore {
+	if err := db.AutoMigrate(&RefreshToken{}).Error; err != nil {
+		log.Panicf("Can't create the grapi_refresh_tokens table: %v", err)
+	}
+	return &dbRefreshTokenStore{db: db}
+}
+
+func (s *dbRefreshTokenStore) Save(t *RefreshToken) error {
+	return s.db.Create(t).Error
+}
+
+func (s *dbRefreshTokenStore) Find(hash string) (*RefreshToken, error) {
+	t := RefreshToken{}
+	find := s.db.Where("hash = ?", hash).First(&t)
+	if find.RecordNotFound() {
+		return nil, nil
+	}
+	return &t, find.Error
+}
+
+func (s *dbRefreshTokenStore) Use(hash string) (bool, error) {
+	update := s.db.Model(&RefreshToken{}).Where("hash = ? AND used = ?", hash, false).UpdateColumn("used", true)
+	return update.RowsAffected == 1, update.Error
+}
+
+func (s *dbRefreshTokenStore) RevokeFamily(family string) error {
+	return s.db.Model(&RefreshToken{}).Where("family = ?", family).UpdateColumn("revoked", true).Error
+}
+
+func (s *dbRefreshTokenStore) RevokeSubject(sub string) error {
+	return s.db.Model(&RefreshToken{}).Where("subject = ?", sub).UpdateColumn("revoked", true).Error
+}
+
+// randomToken returns a random string for use as an opaque token.
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Panicf("Can't generate random token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hashToken returns the hash of a refresh token, under which it is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeTokens sends a new access token for the user with subject sub to the client, along with a
+// refresh token in family (or a new family if family is "") if refresh tokens
+// are enabled.
+func (g *Grapi) writeTokens(c web.C, w http.ResponseWriter, r *http.Request, sub string, family string) {
+	o := g.options
+	claims, err := g.userClaims(sub)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "sub": sub}).Error("Can't get claims")
+		g.writeProblem(c, w, r, NewProblem(500, "Can't issue token"))
+		return
+	}
+	access, err := getJWTToken(sub, claims, g.signingKey(), o.AccessTokenTTL)
+	if err != nil {
+		g.writeProblem(c, w, r, NewProblem(500, "Can't issue token"))
+		return
+	}
+	tokens := map[string]interface{}{
+		"token":      access,
+		"expires_in": int(o.AccessTokenTTL / time.Second),
+	}
+	if o.RefreshTokenTTL > 0 {
+		if family == "" {
+			family = randomToken()
+		}
+		token := randomToken()
+		rt := RefreshToken{Hash: hashToken(token), Family: family, Subject: sub, ExpiresAt: time.Now().Add(o.RefreshTokenTTL)}
+		if err := o.RefreshTokenStore.Save(&rt); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("Can't save refresh token")
+			g.writeProblem(c, w, r, NewProblem(500, "Can't issue refresh token"))
+			return
+		}
+		tokens["refresh_token"] = token
+	}
+	j, _ := json.Marshal(tokens)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// refreshHandler returns the handler for the refresh path set up by SetAuth. It
+// expects a json body of {"refresh_token":"..."}, and if the refresh token is valid
+// uses it up and returns a new access token and refresh token as the login route
+// does. If the refresh token has already been used then every token in its family
+// is revoked, logging out both the client and whoever stole the token.
+func (g *Grapi) refreshHandler() web.HandlerType {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.Unmarshal(httpBody(r), &body); err != nil || body.RefreshToken == "" {
+			g.writeProblem(c, w, r, NewProblem(422, "Expected {\"refresh_token\":\"...\"}"))
+			return
+		}
+		store := g.options.RefreshTokenStore
+		hash := hashToken(body.RefreshToken)
+		rt, err := store.Find(hash)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("Can't find refresh token")
+			g.writeProblem(c, w, r, NewProblem(500, "Database error"))
+			return
+		}
+		if rt == nil || rt.Revoked || rt.ExpiresAt.Before(time.Now()) {
+			log.Warn("Refresh token invalid, revoked or expired")
+			g.writeProblem(c, w, r, NewProblem(401, "Invalid refresh token"))
+			return
+		}
+		fresh, err := store.Use(hash)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("Can't use refresh token")
+			g.writeProblem(c, w, r, NewProblem(500, "Database error"))
+			return
+		}
+		if !fresh {
+			log.WithFields(log.Fields{"sub": rt.Subject}).Warn("Refresh token reused. Revoking its family.")
+			if err := store.RevokeFamily(rt.Family); err != nil {
+				log.WithFields(log.Fields{"error": err}).Error("Can't revoke refresh tokens")
+			}
+			g.writeProblem(c, w, r, NewProblem(401, "Invalid refresh token"))
+			return
+		}
+		if _, err := g.subjectModel().GetBySubject(rt.Subject, g); err != nil {
+			log.WithFields(log.Fields{"sub": rt.Subject}).Warn("Cannot find refreshing user")
+			g.writeProblem(c, w, r, NewProblem(401, "Unknown user"))
+			return
+		}
+		log.Infof("Refreshed token for user %v", rt.Subject)
+		g.writeTokens(c, w, r, rt.Subject, rt.Family)
+	}
+}
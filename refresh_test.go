@@ -0,0 +1,52 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokens(t *testing.T) {
+	api := getTestApi()
+	type tokens struct {
+		Token        string `json:"token"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	login := func() tokens {
+		tk := tokens{}
+		json.Unmarshal([]byte(testReq(t, "Refresh(Login)", "POST", "/api/auth", `{"name": "admin", "password": "password"}`, 200)), &tk)
+		return tk
+	}
+	refresh := func(name string, token string, code int) tokens {
+		tk := tokens{}
+		json.Unmarshal([]byte(testReq(t, name, "POST", "/api/auth/refresh", fmt.Sprintf(`{"refresh_token":%q}`, token), code)), &tk)
+		return tk
+	}
+
+	first := login()
+	if first.Token == "" || first.RefreshToken == "" || first.ExpiresIn != 3600 {
+		t.Fatalf("Login didn't return a refresh token: %+v", first)
+	}
+	second := refresh("Refresh(Refresh)", first.RefreshToken, 200)
+	if second.Token == "" || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Errorf("Refresh didn't return new tokens: %+v", second)
+	}
+	testReq(t, "Refresh(New token)", "GET", "/api/private_widgets?access_token="+second.Token, "", 200)
+
+	other := login()
+	refresh("Refresh(Reused)", first.RefreshToken, 401)
+	refresh("Refresh(Family revoked)", second.RefreshToken, 401)
+	refresh("Refresh(Other family)", other.RefreshToken, 200)
+
+	testReq(t, "Refresh(No token)", "POST", "/api/auth/refresh", `{}`, 422)
+	refresh("Refresh(Unknown token)", "PleaseLetMeIn", 401)
+	api.options.RefreshTokenStore.Save(&RefreshToken{Hash: hashToken("expired"), Family: "expired", Subject: "1",
+		ExpiresAt: time.Now().Add(-time.Minute)})
+	refresh("Refresh(Expired)", "expired", 401)
+
+	disableGetUserById = true
+	refresh("Refresh(User doesn't exist)", login().RefreshToken, 401)
+	disableGetUserById = false
+}
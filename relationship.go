@@ -0,0 +1,178 @@
+package grapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+)
+
+// relationship describes a many to many association of a model, which can be
+// managed through the routes added by AddRelationshipRoutes.
+type relationship struct {
+	name        string // The json name of the association, eg. "groups"
+	field       string // The Go field name of the association, eg. "Groups"
+	targetType  reflect.Type
+	targetTable string
+	targetKey   *modelField
+}
+
+// AddRelationshipRoutes adds routes for linking and unlinking the items in a
+// gorm many2many association. eg. if User has
+//   Groups []Group `gorm:"many2many:user_groups;" json:"groups"`
+// then g.AddRelationshipRoutes(&User{}, "groups") adds:
+//   * GET /api/users/:id/relationships/groups  - Return the ids of the groups linked to the user
+//   * POST /api/users/:id/relationships/groups  - Link the groups whose ids are in the posted json array, eg. [1,2]
+//   * DELETE /api/users/:id/relationships/groups  - Unlink the groups whose ids are in the json array
+// POST and DELETE return the ids of the linked groups after the change.
+//
+// The user is found exactly as for AddGetRoute, so the Authenticate, Authorize and
+// Query callbacks that apply to the user routes should be given in options. If two
+// options are given the first applies to GET and the second to POST and DELETE.
+func (g *Grapi) AddRelationshipRoutes(modelP interface{}, association string, options ...RouteOptions) {
+	if len(options) > 2 {
+		panic("AddRelationshipRoutes called with more than 2 RouteOptions")
+	}
+	viewOption := &RouteOptions{}
+	if len(options) > 0 {
+		viewOption = &options[0]
+	}
+	editOption := viewOption
+	if len(options) > 1 {
+		editOption = &options[1]
+	}
+	viewOption.Initialise(g)
+	editOption.Initialise(g)
+	rel := g.newRelationship(modelP, association)
+	modelType := reflect.TypeOf(modelP).Elem()
+	viewPath := g.makePath(modelP, viewOption) + "/:id/relationships/" + association
+	editPath := g.makePath(modelP, editOption) + "/:id/relationships/" + association
+	log.WithFields(log.Fields{"Model": modelType, "path": viewPath}).Info("Adding relationship routes")
+	g.router.Get(viewPath, g.relationshipHandler(modelType, rel, "GET", viewOption))
+	g.router.Post(editPath, g.relationshipHandler(modelType, rel, "POST", editOption))
+	g.router.Delete(editPath, g.relationshipHandler(modelType, rel, "DELETE", editOption))
+}
+
+// newRelationship finds the many to many association with json name association.
+func (g *Grapi) newRelationship(modelP interface{}, association string) *relationship {
+	for _, sf := range g.db.NewScope(modelP).GetModelStruct().StructFields {
+		rel := sf.Relationship
+		if rel == nil || rel.Kind != "many_to_many" || jsonFieldName(sf.Struct) != association {
+			continue
+		}
+		targetType := sf.Struct.Type
+		for targetType.Kind() == reflect.Slice || targetType.Kind() == reflect.Ptr {
+			targetType = targetType.Elem()
+		}
+		var targetKey *modelField
+		for _, f := range g.getModelFields(targetType) {
+			if f.IsPrimaryKey {
+				targetKey = f
+			}
+		}
+		if targetKey == nil {
+			log.Panicf("%v needs a primary key that is serialised to json", targetType)
+		}
+		return &relationship{
+			name:        association,
+			field:       sf.Name,
+			targetType:  targetType,
+			targetTable: pluralCamelNameType(targetType),
+			targetKey:   targetKey,
+		}
+	}
+	log.Panicf("%T has no many to many association %q", modelP, association)
+	return nil
+}
+
+// relationshipHandler returns a handler which finds the item as itemHandler does,
+// and then lists, links or unlinks the items in the relationship.
+func (g *Grapi) relationshipHandler(itemType reflect.Type, rel *relationship, method string, o *RouteOptions) web.HandlerType {
+	tableName := pluralCamelNameType(itemType)
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		req := request{api: g, DB: g.db, method: method, C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o}
+		defer req.rollbackTx()
+		if true &&
+			(o.Authenticate == nil || o.Authenticate(&req)) &&
+			(o.Authorize == nil || o.Authorize(&req)) &&
+			req.BeginTx() &&
+			(o.Query == nil || o.Query(&req)) &&
+			req.GetItemById() &&
+			(method == "GET" || rel.update(&req)) &&
+			rel.linkedIDs(&req) &&
+			(o.EditResult == nil || o.EditResult(&req)) &&
+			req.CommitTx() &&
+			req.SerialiseResult() {
+			log.WithFields(log.Fields{"Model": itemType, "relationship": rel.name}).Infof("Successful relationship %s", method)
+		} else {
+			req.writeFailure()
+		}
+	}
+}
+
+// linkedIDs replaces r.Result (the item) with the ids of the items linked to it.
+func (rel *relationship) linkedIDs(r *request) bool {
+	linked := getReflectedSlicePtr(reflect.SliceOf(rel.targetType))
+	if err := r.writeDB().Model(r.Result).Association(rel.field).Find(linked).Error; err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Can't find linked items")
+		return r.problem(500, "Database error")
+	}
+	items := reflect.ValueOf(linked).Elem()
+	ids := make([]interface{}, items.Len())
+	for i := range ids {
+		ids[i] = items.Index(i).FieldByName(rel.targetKey.Name).Interface()
+	}
+	r.Result = ids
+	return true
+}
+
+// update links (for POST) or unlinks (for DELETE) the items whose ids are in
+// the uploaded json array.
+func (rel *relationship) update(r *request) bool {
+	var uploaded []json.Number
+	decoder := json.NewDecoder(bytes.NewReader(httpBody(r.R)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&uploaded); err != nil {
+		log.WithFields(log.Fields{"error": err}).Warn("Can't parse incoming json")
+		return r.problem(422, "Expected a json array of ids")
+	}
+	ids := make([]interface{}, 0)
+	seen := make(map[string]bool)
+	for _, u := range uploaded {
+		id, err := rel.targetKey.parseValue(u.String())
+		if err != nil {
+			return r.problem(422, fmt.Sprintf("Bad id %s", u.String()))
+		}
+		if !seen[u.String()] {
+			seen[u.String()] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return true
+	}
+	targets := getReflectedSlicePtr(reflect.SliceOf(rel.targetType))
+	qstring := fmt.Sprintf("%s.%s IN (?)", rel.targetTable, rel.targetKey.DBName)
+	if err := r.writeDB().Where(qstring, ids).Find(targets).Error; err != nil {
+		return r.dbError(err)
+	}
+	if reflect.ValueOf(targets).Elem().Len() != len(ids) {
+		log.WithFields(log.Fields{"ids": ids}).Warn("Can't link unknown items")
+		return r.problem(422, "Unknown id")
+	}
+	association := r.writeDB().Model(r.Result).Association(rel.field)
+	if r.method == "POST" {
+		association = association.Append(reflect.ValueOf(targets).Elem().Interface())
+	} else {
+		association = association.Delete(reflect.ValueOf(targets).Elem().Interface())
+	}
+	if association.Error != nil {
+		log.WithFields(log.Fields{"error": association.Error}).Error("Can't update relationship")
+		return r.problem(500, "Database error")
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package grapi
+
+import (
+	"testing"
+)
+
+// Models with a many to many association for testing relationship routes
+type Member struct {
+	ID    uint   `gorm:"primary_key" json:"id"`
+	Name  string `json:"name"`
+	Teams []Team `gorm:"many2many:member_teams;" json:"teams"`
+}
+
+type Team struct {
+	ID   uint   `gorm:"primary_key" json:"id"`
+	Name string `json:"name"`
+}
+
+func TestRelationshipRoutes(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	db.DropTable(&Member{})
+	db.DropTable(&Team{})
+	db.DropTable("member_teams")
+	db.AutoMigrate(&Member{}, &Team{})
+	for _, name := range []string{"Red", "Green", "Blue"} {
+		db.Create(&Team{Name: name})
+	}
+	db.Create(&Member{Name: "Linked", Teams: []Team{{ID: 1}}})
+	db.Create(&Member{Name: "Hidden"})
+
+	api.AddRelationshipRoutes(&Member{}, "teams", RouteOptions{
+		Query: func(req ReqToLimit) bool {
+			req.SetDB(req.GetDB().Where("name <> ?", "Hidden"))
+			return true
+		}})
+
+	for _, test := range []struct {
+		name     string
+		method   string
+		body     string
+		code     int
+		expected string
+	}{
+		{"List", "GET", "", 200, "[1]"},
+		{"Link", "POST", "[2,3,3]", 200, "[1,2,3]"},
+		{"Unlink", "DELETE", "[1,3]", 200, "[2]"},
+		{"Link(unknown)", "POST", "[2,42]", 422, ""},
+		{"Link(malformed)", "POST", `{"id":2}`, 422, ""},
+		{"List(after failure)", "GET", "", 200, "[2]"},
+	} {
+		body := testReq(t, "Relationship("+test.name+")", test.method, "/api/members/1/relationships/teams", test.body, test.code)
+		if test.code == 200 && body != test.expected {
+			t.Errorf("Relationship(%s) returned %s instead of %s", test.name, body, test.expected)
+		}
+	}
+	testReq(t, "Relationship(hidden)", "GET", "/api/members/2/relationships/teams", "", 404)
+
+	// A database error looking up the ids isn't mistaken for unknown ids
+	db.DropTable(&Team{})
+	testReq(t, "Relationship(database error)", "POST", "/api/members/1/relationships/teams", "[2]", 500)
+	db.AutoMigrate(&Team{})
+
+	defer ensurePanic(t, "Added relationship routes for an unknown association")
+	api.AddRelationshipRoutes(&Member{}, "leagues")
+}
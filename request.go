@@ -1,10 +1,13 @@
 package grapi
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"reflect"
+	"strings"
 
 	"github.com/jinzhu/gorm"
 	"github.com/zenazn/goji/web"
@@ -28,13 +31,31 @@ type request struct {
 	Type      reflect.Type
 	TableName string
 	options   *RouteOptions
+	columns   modelFields
+	page      *pageInfo
+	fields    map[string]bool   // json names of the fields selected with ?fields=
+	includes  map[string]string // associations that can be included, mapped to their gorm Preload path
+	included  map[string]bool   // json names of the associations included with ?include=
 
 	DB          *gorm.DB
 	api         *Grapi
 	method      string // 'GET', 'POST', 'PUT', 'PATCH' or 'DELETE'
 	Result      interface{}
 	Uploaded    interface{}
-	LoginObject interface{} // An object that describes the authenticated user.
+	LoginObject interface{}            // An object that describes the authenticated user.
+	claims      map[string]interface{} // The claims of the authenticated user's token.
+	parent      interface{}            // For nested routes, the parent item of the one(s) in this request.
+	creating    bool                   // For PUT requests, true if the item doesn't exist yet.
+	version     int64                  // The version of the item when it was loaded, if the route has a VersionColumn.
+	failure     error                  // The error given to Fail, if any.
+	tx          *gorm.DB               // The transaction for this request, if RouteOptions.UseTransaction is set.
+	status      int                    // The http status for a successful response, if not 200.
+	deletedAt   string                 // The DeletedAt column, if the model is soft deleted.
+	hardDelete  bool                   // For DELETE requests, true if a soft deleted model is to be removed for good.
+	bulk        bool                   // True for a bulk request on many items (see RouteOptions.Bulk).
+	items       []*request             // For a bulk request, a request for each of its items.
+	inBatch     bool                   // True for the request of one item of a bulk request.
+	idempotency *idempotencyKey        // The record of the Idempotency-Key, if the request has one.
 
 	Data interface{} // User defined data that can be stored in the request object.
 }
@@ -94,6 +115,17 @@ func (r *request) SetLoginObject(lo interface{}) {
 	r.LoginObject = lo
 }
 
+//GetClaims returns the claims set with SetClaims, fulfilling RequestLoginInfo.
+func (r *request) GetClaims() map[string]interface{} {
+	return r.claims
+}
+
+//SetClaims is required by ReqToAuthenticate, and stores the claims of the token
+//the request was authenticated with.
+func (r *request) SetClaims(claims map[string]interface{}) {
+	r.claims = claims
+}
+
 //GetDB() returns the underlying DB for this request, and is needed to fulfill ReqToLimit
 func (r *request) GetDB() *gorm.DB {
 	return r.DB
@@ -127,8 +159,7 @@ func (r *request) GetItemById() bool {
 	item := reflect.New(r.Type).Interface()
 	qstring := fmt.Sprintf("%s.id = ?", r.TableName)
 	if r.DB.Where(qstring, id).Find(item).RecordNotFound() {
-		http.Error(r.W, "Not Found", 404)
-		return false
+		return r.problem(404, "")
 	}
 	r.Result = item
 	return true
@@ -149,99 +180,157 @@ func (r *request) ParseUpload() bool {
 	item := reflect.New(r.Type).Interface()
 	if err := json.Unmarshal(body, item); err != nil {
 		log.WithFields(log.Fields{"error": err}).Warn("Can't parse incoming json")
-		r.W.WriteHeader(422) // unprocessable entity
-		return false
+		return r.problem(422, "Can't parse json: "+err.Error())
 	}
 	r.Uploaded = item
+	return r.validateUpload()
+}
+
+// validateUpload calls ValidateUpload on r.Uploaded if it implements NeedsValidation,
+// and returns a 422 with the field errors if it fails.
+func (r *request) validateUpload() bool {
 	switch r.Uploaded.(type) {
 	case NeedsValidation:
 		err := r.Uploaded.(NeedsValidation).ValidateUpload()
 		if err != nil && len(err) != 0 {
 			log.WithFields(log.Fields{"error": err}).Warn("Validation error")
-			j, _ := json.Marshal(err)
-			http.Error(r.W, fmt.Sprintf(`{"errors":%v}`, string(j)), 422)
-			return false
+			p := NewProblem(422, "Validation failed")
+			p.Errors = err
+			return r.writeProblem(p)
 		}
 	}
 	return true
 }
 
-// PostDB saves the object in r.Uploaded to the db. We use the original DB object from
-// API instead of r.DB as r.DB may have been edited with joins etc. and this breaks things.
+// PostDB saves the object in r.Uploaded to the db. We use writeDB() (the original DB object
+// from API, or the request's transaction) instead of r.DB as r.DB may have been edited
+// with joins etc. and this breaks things.
 func (r *request) PostDB() bool {
 	uploaded := r.Uploaded
 	log.Printf("upload is a %T\n", uploaded)
 
-	post := r.api.db.Create(r.Uploaded)
-	err := post.Error
-	if err != nil {
-		log.Warn("Error creating in doCreate: ", err)
-		r.W.WriteHeader(422)
-		return false
+	if err := r.writeDB().Create(r.Uploaded).Error; err != nil {
+		return r.dbError(err)
 	}
 	r.Result = r.Uploaded
 	return true
 }
 
+// SetCreated sets the status to 201 Created, and the Location header to the url of
+// the new item, if a POST (or a PUT with RouteOptions.AllowCreateOnPut) has created
+// an item. A bulk POST has no single Location.
+func (r *request) SetCreated() bool {
+	location := strings.TrimSuffix(r.R.URL.Path, "/")
+	switch {
+	case r.method == "POST" && r.bulk:
+		r.status = http.StatusCreated
+		return true
+	case r.method == "POST":
+		id, err := getID(r.Uploaded)
+		if err != nil {
+			return true
+		}
+		location += "/" + url.PathEscape(fmt.Sprint(id))
+	case r.method == "PUT" && r.creating:
+	default:
+		return true
+	}
+	r.W.Header().Set("Location", location)
+	r.status = http.StatusCreated
+	return true
+}
+
 // PatchResultWithUploaded unmarshals the uploaded item into r.Uploaded, merging it with
 // the object in r.Result. GetItemById must have been called before this to fill r.Result
-// with the contents of the existing item from the database.
+// with the contents of the existing item from the database. Json merge patches and json
+// patches are applied according to the Content-Type (see applyPatch).
 func (r *request) PatchResultWithUploaded() bool {
-	body := httpBody(r.R)
+	return r.patchResult(httpBody(r.R))
+}
+
+// patchResult applies the patch in body to r.Result, for PatchResultWithUploaded.
+func (r *request) patchResult(body []byte) bool {
 	beforeID, _ := getID(r.Result)
-	if err := json.Unmarshal(body, r.Result); err != nil {
-		log.WithFields(log.Fields{"error": err}).Warn("Can't parse incoming json")
-		r.W.WriteHeader(422) // unprocessable entity
-		return false
+	if err := r.applyPatch(body); err != nil {
+		log.WithFields(log.Fields{"error": err}).Warn("Can't apply patch")
+		if err == errPatchTestFailed {
+			return r.problem(409, err.Error())
+		}
+		return r.problem(422, err.Error()) // unprocessable entity
 	}
 	afterID, _ := getID(r.Result)
 	if beforeID != afterID {
 		log.WithFields(log.Fields{"afterID": afterID, "beforeID": beforeID}).Warn("Patch trying to change ID")
-		r.W.WriteHeader(422) // unprocessable entity
-		return false
+		return r.problem(422, "Can't change the id") // unprocessable entity
 	}
 	r.Uploaded = r.Result
-	switch r.Uploaded.(type) {
-	case NeedsValidation:
-		err := r.Uploaded.(NeedsValidation).ValidateUpload()
-		if err != nil && len(err) != 0 {
-			log.WithFields(log.Fields{"error": err}).Warn("Validation error")
-			j, _ := json.Marshal(err)
-			http.Error(r.W, fmt.Sprintf(`{"errors":%v}`, string(j)), 422)
-			return false
-		}
-	}
-	return true
+	return r.validateUpload()
 }
 
-// PatchDB saves the object in r.Uploaded to the db. We use the original DB object from
-// API instead of r.DB as r.DB may have been edited with joins etc. and this breaks things.
+// PatchDB saves the object in r.Uploaded to the db. We use writeDB() (the original DB object
+// from API, or the request's transaction) instead of r.DB as r.DB may have been edited
+// with joins etc. and this breaks things.
 func (r *request) PatchDB() bool {
-	r.api.db.Save(r.Uploaded)
+	if r.options.VersionColumn != "" {
+		return r.versionedUpdate()
+	}
+	if err := r.writeDB().Save(r.Uploaded).Error; err != nil {
+		return r.dbError(err)
+	}
 	r.Result = r.Uploaded
 	return true
 }
 
-// DeleteFromDB deletes the object in r.Result from the db. We use the original DB object from
-// API instead of r.DB as r.DB may have been edited with joins etc. and this breaks things.
+// DeleteFromDB deletes the object in r.Result from the db. We use writeDB() (the original DB object
+// from API, or the request's transaction) instead of r.DB as r.DB may have been edited
+// with joins etc. and this breaks things.
 func (r *request) DeleteFromDB() bool {
 	log.WithFields(log.Fields{"item": r.Result}).Info("Deleting")
-	r.api.db.Delete(r.Result)
+	if r.options.VersionColumn != "" {
+		return r.versionedDelete()
+	}
+	if err := r.deleteDB().Delete(r.Result).Error; err != nil {
+		return r.dbError(err)
+	}
 	return true
 }
 
-// SerialiseResult Serialise r.Result to json and sends it back down the wire
+// badRequest logs and returns a 400 error, explaining the problem with the
+// request's parameters.
+func (r *request) badRequest(err error) bool {
+	log.WithFields(log.Fields{"error": err}).Warn("Bad request")
+	return r.problem(400, err.Error())
+}
+
+// SerialiseResult Serialise r.Result to json and sends it back down the wire. For
+// GET requests a 304 Not Modified is sent instead if the client already has it. If
+// RouteOptions.EmptyResponses is set then writes are answered without a body, with
+// 204 No Content (or 201 Created).
 func (r *request) SerialiseResult() bool {
+	if r.method != "GET" && r.options.EmptyResponses {
+		if r.status == 0 {
+			r.status = http.StatusNoContent
+		}
+		r.W.WriteHeader(r.status)
+		return true
+	}
 	if r.Result == nil {
 		log.Errorf("Serialise empty result")
-		http.Error(r.W, "Not Found", 404)
-		return false
+		return r.problem(404, "")
 	}
-	err := json.NewEncoder(r.W).Encode(r.Result)
+	var body bytes.Buffer
+	err := json.NewEncoder(&body).Encode(r.Result)
 	if err != nil {
 		log.Errorf("JSON Encode fail: %v", err)
-		http.Error(r.W, `{"msg":"Failed to encode JSON"}`, 422)
-		return false
+		return r.problem(422, "Failed to encode JSON")
+	}
+	if r.method == "GET" && r.notModified(body.Bytes()) {
+		r.W.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	if r.status != 0 {
+		r.W.WriteHeader(r.status)
 	}
+	r.W.Write(body.Bytes())
 	return true
 }
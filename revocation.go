@@ -0,0 +1,176 @@
+package grapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/zenazn/goji/web"
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// RevocationList keeps track of access tokens which have been revoked before they
+// expired. It is consulted by the default authenticator. Set Options.RevocationList
+// to keep it somewhere other than the database.
+type RevocationList interface {
+	// Revoke revokes the token with the given jti claim. It expires at expiresAt, after
+	// which it doesn't need to be remembered.
+	Revoke(jti string, expiresAt time.Time) error
+	// RevokeSubject revokes every token issued to the user with this subject at or
+	// before before.
+	RevokeSubject(sub string, before time.Time) error
+	// IsRevoked returns true if the token with the given jti, issued to the user with
+	// subject sub at issuedAt, has been revoked.
+	IsRevoked(jti string, sub string, issuedAt time.Time) (bool, error)
+}
+
+// revokedToken is a token revoked with RevocationList.Revoke, stored in the
+// grapi_revoked_tokens table.
+type revokedToken struct {
+	JTI       string `gorm:"primary_key"`
+	ExpiresAt time.Time
+}
+
+func (revokedToken) TableName() string {
+	return "grapi_revoked_tokens"
+}
+
+// revokedSessions records that a user's tokens have been revoked with
+// RevocationList.RevokeSubject, in the grapi_revoked_sessions table.
+type revokedSessions struct {
+	Subject         string `gorm:"primary_key"`
+	RevokedBeforeUS int64  // Tokens issued at or before this unix time in microseconds are revoked
+}
+
+func (revokedSessions) TableName() string {
+	return "grapi_revoked_sessions"
+}
+
+// dbRevocationList is the default RevocationList, which keeps revoked tokens in the
+// grapi_revoked_tokens and grapi_revoked_sessions tables.
+type dbRevocationList struct {
+	db *gorm.DB
+}
+
+// newDBRevocationList returns a RevocationList using db, creating its tables if
+// needed.
+func newDBRevocationList(db *gorm.DB) *dbRevocationList {
+	if err := db.AutoMigrate(&revokedToken{}, &revokedSessions{}).Error; err != nil {
+		log.Panicf("Can't create the grapi revocation tables: %v", err)
+	}
+	return &dbRevocationList{db: db}
+}
+
+func (l *dbRevocationList) Revoke(jti string, expiresAt time.Time) error {
+	l.db.Where("expires_at < ?", time.Now()).Delete(&revokedToken{})
+	return l.db.Save(&revokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (l *dbRevocationList) RevokeSubject(sub string, before time.Time) error {
+	return l.db.Save(&revokedSessions{Subject: sub, RevokedBeforeUS: before.UnixNano() / int64(time.Microsecond)}).Error
+}
+
+func (l *dbRevocationList) IsRevoked(jti string, sub string, issuedAt time.Time) (bool, error) {
+	if jti != "" {
+		find := l.db.Where("jti = ?", jti).First(&revokedToken{})
+		if !find.RecordNotFound() {
+			return find.Error == nil, find.Error
+		}
+	}
+	sessions := revokedSessions{}
+	find := l.db.Where("subject = ?", sub).First(&sessions)
+	if find.RecordNotFound() {
+		return false, nil
+	}
+	return issuedAt.UnixNano()/int64(time.Microsecond) <= sessions.RevokedBeforeUS, find.Error
+}
+
+// claimTime returns the time in a numeric jwt claim, such as exp or iat.
+func claimTime(token *jwt.Token, claim string) time.Time {
+	t, _ := token.Claims[claim].(float64)
+	return time.Unix(int64(t), 0)
+}
+
+// tokenRevoked returns true if the access token has been revoked, either on its
+// own or with the rest of the user's tokens.
+func (g *Grapi) tokenRevoked(token *jwt.Token) (bool, error) {
+	list := g.options.RevocationList
+	if list == nil {
+		return false, nil
+	}
+	jti, _ := token.Claims["jti"].(string)
+	return list.IsRevoked(jti, tokenSubject(token), issuedAt(token))
+}
+
+// issuedAt returns the time the token was issued, to the microsecond if it has an
+// iat_us claim.
+func issuedAt(token *jwt.Token) time.Time {
+	if us, ok := token.Claims["iat_us"].(float64); ok {
+		return time.Unix(0, int64(us)*int64(time.Microsecond))
+	}
+	return claimTime(token, "iat")
+}
+
+// RevokeAllSessions logs a user out everywhere, by revoking every access token and
+// refresh token that has been issued to them. eg. call it when the user changes
+// their password.
+func (g *Grapi) RevokeAllSessions(userID uint) error {
+	return g.RevokeSubjectSessions(strconv.FormatUint(uint64(userID), 10))
+}
+
+// RevokeSubjectSessions is RevokeAllSessions for a user of a SubjectLoginModel.
+func (g *Grapi) RevokeSubjectSessions(sub string) error {
+	log.WithFields(log.Fields{"sub": sub}).Info("Revoking all sessions")
+	if list := g.options.RevocationList; list != nil {
+		if err := list.RevokeSubject(sub, time.Now()); err != nil {
+			return err
+		}
+	}
+	if store := g.options.RefreshTokenStore; store != nil {
+		return store.RevokeSubject(sub)
+	}
+	return nil
+}
+
+// logoutHandler returns the handler for the logout path set up by SetAuth. It
+// revokes the access token the request is authenticated with, and if the body is
+// {"refresh_token":"..."} then that refresh token's family too. It returns 204 No
+// Content.
+func (g *Grapi) logoutHandler() web.HandlerType {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		json.Unmarshal(httpBody(r), &body)
+		token, err := g.parseToken(r)
+		if token == nil || !token.Valid {
+			log.WithFields(log.Fields{"error": err}).Warn("Logout: JWT token did not validate")
+			g.writeProblem(c, w, r, NewProblem(401, "Invalid or missing token"))
+			return
+		}
+		jti, _ := token.Claims["jti"].(string)
+		if jti == "" {
+			g.writeProblem(c, w, r, NewProblem(422, "Token can't be revoked"))
+			return
+		}
+		if err := g.options.RevocationList.Revoke(jti, claimTime(token, "exp")); err != nil {
+			log.WithFields(log.Fields{"error": err}).Error("Can't revoke token")
+			g.writeProblem(c, w, r, NewProblem(500, "Database error"))
+			return
+		}
+		if store := g.options.RefreshTokenStore; store != nil && body.RefreshToken != "" {
+			rt, err := store.Find(hashToken(body.RefreshToken))
+			if err == nil && rt != nil && rt.Subject == tokenSubject(token) {
+				err = store.RevokeFamily(rt.Family)
+			}
+			if err != nil {
+				log.WithFields(log.Fields{"error": err}).Error("Can't revoke refresh token")
+			}
+		}
+		log.WithFields(log.Fields{"sub": tokenSubject(token)}).Info("Logged out")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,50 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestRevocation(t *testing.T) {
+	api := getTestApi()
+	user := User{Name: "revocable", Password: "password"}
+	api.DB().Create(&user)
+	type tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	login := func() tokens {
+		tk := tokens{}
+		json.Unmarshal([]byte(testReq(t, "Revoke(Login)", "POST", "/api/auth", `{"name": "revocable", "password": "password"}`, 200)), &tk)
+		return tk
+	}
+	auth := func(name string, tk tokens, code int) {
+		testReq(t, name, "GET", "/api/private_widgets?access_token="+tk.Token, "", code)
+	}
+	refresh := func(name string, tk tokens, code int) {
+		testReq(t, name, "POST", "/api/auth/refresh", fmt.Sprintf(`{"refresh_token":%q}`, tk.RefreshToken), code)
+	}
+
+	session := login()
+	auth("Revoke(Before logout)", session, 200)
+	testReq(t, "Revoke(Logout)", "POST", "/api/auth/logout?access_token="+session.Token,
+		fmt.Sprintf(`{"refresh_token":%q}`, session.RefreshToken), 204)
+	auth("Revoke(After logout)", session, 401)
+	refresh("Revoke(Refresh after logout)", session, 401)
+	testReq(t, "Revoke(Logout without token)", "POST", "/api/auth/logout", "", 401)
+
+	laptop, phone := login(), login()
+	auth("Revoke(Laptop)", laptop, 200)
+	if err := api.RevokeAllSessions(user.ID); err != nil {
+		t.Fatalf("Can't revoke sessions: %v", err)
+	}
+	auth("Revoke(Laptop after revoke all)", laptop, 401)
+	auth("Revoke(Phone after revoke all)", phone, 401)
+	refresh("Revoke(Refresh after revoke all)", phone, 401)
+	auth("Revoke(Other user)", tokens{Token: getToken(testReq(t, "Revoke(Admin login)", "POST", "/api/auth",
+		`{"name": "admin", "password": "password"}`, 200))}, 200)
+
+	// Logging in again straight away, in the same second, gives a token that isn't revoked
+	auth("Revoke(Login again)", login(), 200)
+}
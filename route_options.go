@@ -33,6 +33,98 @@ type RouteOptions struct {
 	// back to the user. You can change that behaviour here.
 	EditResult ResultEditor
 
+	// By default POST, PUT, PATCH and DELETE return the created, edited or deleted
+	// item. If EmptyResponses is set they instead return no body, with status
+	// 204 No Content (or 201 Created with a Location header for a new item).
+	EmptyResponses bool
+
+	// If UseTransaction is set then POST, PUT, PATCH and DELETE requests run inside a
+	// database transaction, which is only committed if every callback succeeds. It is
+	// rolled back if any callback returns false, or on a panic. Callbacks can make their
+	// own writes in the transaction using req.GetTx().
+	UseTransaction bool
+
+	// For models with a DeletedAt field, which gorm soft deletes, AuthorizeDeleted decides
+	// whether GET requests may use ?with_deleted=true or ?only_deleted=true to see soft
+	// deleted items. If it isn't set they return 403 Forbidden.
+	AuthorizeDeleted Authorizor
+
+	// If AllowHardDelete is set then DELETE ?hard=true removes a soft deleted model's item
+	// from the database for good.
+	AllowHardDelete bool
+
+	// If Bulk is set then a json array can be POSTed to create many items at once,
+	// PATCH /api/widgets takes an array of items with their ids to edit many items,
+	// and DELETE /api/widgets?ids=1,2,3 deletes many items. Each item is validated and
+	// passed to CheckUpload in turn, and all are written in one transaction, so if any
+	// item fails then none are written and the errors of each failed item are returned.
+	// MaxBatchSize is the most items allowed in one request, by default 100.
+	Bulk         bool
+	MaxBatchSize int
+
+	// If UseIdempotencyKey is set then a POST or PATCH with an Idempotency-Key header has
+	// its response stored (in the grapi_idempotency_keys table) for Options.IdempotencyWindow.
+	// A retry with the same key and body gets the stored response instead of being run
+	// again. Reusing a key with a different body returns 422 Unprocessable Entity, and a
+	// retry while the first request is still running returns 409 Conflict.
+	UseIdempotencyKey bool
+
+	// FilterFields optionally restricts which fields can be used to filter index
+	// routes with query parameters (eg. ?name=foo or ?age[gt]=30). Fields are named
+	// by their json name. If nil then any field that is serialised to json can be
+	// used. Use this to stop clients probing fields they can't otherwise see.
+	FilterFields []string
+
+	// SortFields optionally restricts which fields the client can sort an index route
+	// by with ?sort=-created_at,name . If nil any field that is serialised to json can
+	// be used. DefaultSort is used when the client doesn't give a sort parameter, and
+	// takes the same form.
+	SortFields  []string
+	DefaultSort string
+
+	// RequiredFields lists the json names of fields that must be present in the body
+	// of a PUT request. Other fields missing from a PUT are reset to their zero value.
+	RequiredFields []string
+
+	// If AllowCreateOnPut is set then a PUT to an item that doesn't exist will create
+	// it with the id in the url, for clients that generate their own ids.
+	AllowCreateOnPut bool
+
+	// VersionColumn optionally names (by json name) an integer field holding the
+	// version of each item. It is used as the ETag, and is incremented on each
+	// PUT or PATCH. The UPDATE (or DELETE) only matches the row if the version
+	// hasn't changed since the item was loaded, so concurrent writers get a 412
+	// Precondition Failed rather than silently overwriting each other. Without a
+	// VersionColumn the ETag is a hash of the item's fields.
+	VersionColumn string
+
+	// Includes lists the associations that a client may ask to be loaded with the
+	// item(s) on GET routes with ?include= . Associations are named by their json
+	// name, and nested associations are separated by dots. eg. for a User that has
+	// many PrivateWidgets which have many Parts:
+	//   Includes: []string{"private_widgets.parts"}
+	// allows both ?include=private_widgets and ?include=private_widgets.parts
+	Includes []string
+
+	// DefaultPageSize and MaxPageSize override the values in Options for this route.
+	DefaultPageSize int
+	MaxPageSize     int
+
+	// By default the total count and links to other pages of a paginated index are
+	// returned in the X-Total-Count and Link headers. If PaginationEnvelope is set the
+	// list is instead returned as {"data":[...],"meta":{"total":n,"limit":n,"offset":n}}
+	PaginationEnvelope bool
+
+	// CursorPagination replaces limit/offset pagination with keyset pagination, which
+	// stays fast and consistent on large tables that are being written to. Items are
+	// ordered by CursorField (a json field name, prefixed with "-" for descending order)
+	// or by primary key if that is empty. Each page returns an opaque cursor for the next
+	// page in the X-Next-Cursor header, which the client passes back as ?cursor=.
+	// Cursors are signed with Options.JwtKey, which must be set. CursorField can't be
+	// NULL-able (a pointer or sql.Null type).
+	CursorPagination bool
+	CursorField      string
+
 	initialised bool
 }
 
@@ -51,3 +143,29 @@ func (ro *RouteOptions) Initialise(g *Grapi) {
 	}
 	ro.Authenticate = g.defaultAuthenticator()
 }
+
+// canSort returns true if the index route may be sorted by field f.
+func (ro *RouteOptions) canSort(f *modelField) bool {
+	if ro.SortFields == nil {
+		return true
+	}
+	for _, name := range ro.SortFields {
+		if name == f.JSONName {
+			return true
+		}
+	}
+	return false
+}
+
+// canFilter returns true if the index route may be filtered on field f.
+func (ro *RouteOptions) canFilter(f *modelField) bool {
+	if ro.FilterFields == nil {
+		return true
+	}
+	for _, name := range ro.FilterFields {
+		if name == f.JSONName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package grapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/zenazn/goji/web"
+)
+
+func init() {
+	reservedParams["with_deleted"] = true
+	reservedParams["only_deleted"] = true
+	reservedParams["hard"] = true
+}
+
+// deletedAtColumn returns the database column of the DeletedAt field that gorm
+// uses to soft delete items of type t, or "" if t isn't soft deleted.
+func (g *Grapi) deletedAtColumn(t reflect.Type) string {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	field, ok := g.db.NewScope(reflect.New(t).Interface()).FieldByName("DeletedAt")
+	if !ok {
+		return ""
+	}
+	return field.DBName
+}
+
+// boolParam returns the value of the boolean query parameter name, which is
+// false if it's missing.
+func (r *request) boolParam(name string) (bool, error) {
+	s := r.R.URL.Query().Get(name)
+	if s == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return b, nil
+}
+
+// ScopeDeleted handles ?with_deleted=true and ?only_deleted=true on GET routes for
+// models that gorm soft deletes (ie. they have a DeletedAt field). These include
+// soft deleted items, or return only soft deleted items. They must be authorized
+// by RouteOptions.AuthorizeDeleted, and return 403 Forbidden if it isn't set.
+func (r *request) ScopeDeleted() bool {
+	with, err := r.boolParam("with_deleted")
+	if err != nil {
+		return r.badRequest(err)
+	}
+	only, err := r.boolParam("only_deleted")
+	if err != nil {
+		return r.badRequest(err)
+	}
+	if !with && !only {
+		return true
+	}
+	if r.deletedAt == "" {
+		return r.badRequest(fmt.Errorf("This model can't be soft deleted"))
+	}
+	if !r.authorizeDeleted() {
+		return false
+	}
+	r.DB = r.DB.Unscoped()
+	if only {
+		r.DB = r.DB.Where(fmt.Sprintf("%s.%s IS NOT NULL", r.TableName, r.deletedAt))
+	}
+	return true
+}
+
+// authorizeDeleted calls RouteOptions.AuthorizeDeleted, or returns a 403 if it
+// isn't set.
+func (r *request) authorizeDeleted() bool {
+	if r.options.AuthorizeDeleted == nil {
+		log.Warn("Access to deleted items is not authorized on this route")
+		return r.problem(http.StatusForbidden, "Access to deleted items is not allowed")
+	}
+	return r.options.AuthorizeDeleted(r)
+}
+
+// CheckHardDelete handles ?hard=true on DELETE routes, which deletes the item from
+// the database rather than soft deleting it. This is only allowed if the route has
+// RouteOptions.AllowHardDelete. An item which has already been soft deleted can
+// also be hard deleted.
+func (r *request) CheckHardDelete() bool {
+	hard, err := r.boolParam("hard")
+	if err != nil {
+		return r.badRequest(err)
+	}
+	if !hard || r.deletedAt == "" {
+		return true
+	}
+	if !r.options.AllowHardDelete {
+		log.Warn("Hard delete is not allowed on this route")
+		return r.problem(http.StatusForbidden, "Hard delete is not allowed")
+	}
+	r.hardDelete = true
+	r.DB = r.DB.Unscoped()
+	return true
+}
+
+// deleteDB returns the DB object to delete with, which ignores soft deletion for
+// a hard delete.
+func (r *request) deleteDB() *gorm.DB {
+	if r.hardDelete {
+		return r.writeDB().Unscoped()
+	}
+	return r.writeDB()
+}
+
+// GetDeletedItemById is GetItemById for items which have been soft deleted.
+func (r *request) GetDeletedItemById() bool {
+	item := reflect.New(r.Type).Interface()
+	qstring := fmt.Sprintf("%s.id = ? AND %s.%s IS NOT NULL", r.TableName, r.TableName, r.deletedAt)
+	if r.DB.Unscoped().Where(qstring, r.Param("id")).Find(item).RecordNotFound() {
+		return r.problem(404, "")
+	}
+	r.Result = item
+	return true
+}
+
+// RestoreDB undeletes the soft deleted item in r.Result.
+func (r *request) RestoreDB() bool {
+	if err := r.writeDB().Unscoped().Model(r.Result).UpdateColumn(r.deletedAt, nil).Error; err != nil {
+		return r.dbError(err)
+	}
+	deletedAt := reflect.ValueOf(r.Result).Elem().FieldByName("DeletedAt")
+	deletedAt.Set(reflect.Zero(deletedAt.Type()))
+	return true
+}
+
+// AddRestoreRoute adds a route at "#{g.prefix}/#{pluralmodelname}/:id/restore" to
+// undelete a soft deleted item. ie. g.AddRestoreRoute(&Widget{}, nil) adds a route
+// such that POST "/api/widgets/2/restore" restores the widget with id==2. It is
+// added by AddDefaultRoutes for models with a DeletedAt field.
+func (g *Grapi) AddRestoreRoute(modelP interface{}, ro *RouteOptions) {
+	if ro == nil {
+		ro = &RouteOptions{}
+	}
+	ro.Initialise(g)
+	modelType := reflect.TypeOf(modelP).Elem()
+	if g.deletedAtColumn(modelType) == "" {
+		log.Panicf("%v can't be restored as it has no DeletedAt field", modelType)
+	}
+	path := g.makePath(modelP, ro) + "/:id/restore"
+	log.WithFields(log.Fields{"Model": modelType, "path": path}).Info("Adding restore route")
+	g.router.Post(path, g.restoreHandler(modelType, ro))
+}
+
+// restoreHandler returns a handler for restoring soft deleted items.
+func (g *Grapi) restoreHandler(itemType reflect.Type, o *RouteOptions) web.HandlerType {
+	tableName := pluralCamelNameType(itemType)
+	columns := g.getModelFields(itemType)
+	deletedAt := g.deletedAtColumn(itemType)
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		req := request{api: g, DB: g.db, method: "POST", C: c, W: w, R: r, Type: itemType, TableName: tableName, options: o,
+			columns: columns, deletedAt: deletedAt}
+		defer req.rollbackTx()
+		if true &&
+			(o.Authenticate == nil || o.Authenticate(&req)) &&
+			(o.Authorize == nil || o.Authorize(&req)) &&
+			req.BeginTx() &&
+			(o.Query == nil || o.Query(&req)) &&
+			req.GetDeletedItemById() &&
+			req.RestoreDB() &&
+			(o.EditResult == nil || o.EditResult(&req)) &&
+			req.CommitTx() &&
+			req.SerialiseResult() {
+			log.WithFields(log.Fields{"Model": itemType}).Info("Successful restore")
+		} else {
+			req.writeFailure()
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type SoftWidget struct {
+	ID        uint       `gorm:"primary_key" json:"id"`
+	Name      string     `json:"name"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+func TestSoftDelete(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	db.DropTable(&SoftWidget{})
+	db.CreateTable(&SoftWidget{})
+	kept := SoftWidget{Name: "Kept"}
+	binned := SoftWidget{Name: "Binned"}
+	db.Create(&kept)
+	db.Create(&binned)
+
+	admin := map[string]string{"X-Admin": "yes"}
+	api.AddDefaultRoutes(&SoftWidget{}, RouteOptions{
+		AllowHardDelete: true,
+		AuthorizeDeleted: func(req ReqToAuthorize) bool {
+			if req.GetRequest().Header.Get("X-Admin") != "yes" {
+				return req.Fail(NewError(403, "admin_only", "Only admin can see deleted widgets", nil))
+			}
+			return true
+		}})
+	api.AddDefaultRoutes(&SoftWidget{}, RouteOptions{UriModelName: "unauthorised_soft_widgets"})
+
+	names := func(name string, uri string, headers map[string]string) map[string]bool {
+		widgets := []SoftWidget{}
+		rec := testReqWithHeaders(t, name, "GET", uri, "", headers, 200)
+		json.Unmarshal(rec.Body.Bytes(), &widgets)
+		found := make(map[string]bool)
+		for _, w := range widgets {
+			found[w.Name] = true
+		}
+		return found
+	}
+	uri := fmt.Sprintf("/api/soft_widgets/%d", binned.ID)
+	testReq(t, "SoftDelete(DELETE)", "DELETE", uri, "", 200)
+	if err := db.Unscoped().First(&SoftWidget{}, binned.ID).Error; err != nil {
+		t.Errorf("Soft deleted item was removed from the database: %v", err)
+	}
+	testReq(t, "SoftDelete(GET deleted)", "GET", uri, "", 404)
+	if found := names("SoftDelete(index)", "/api/soft_widgets", nil); !found["Kept"] || found["Binned"] {
+		t.Errorf("Index should only have undeleted items: %v", found)
+	}
+	if found := names("SoftDelete(with_deleted)", "/api/soft_widgets?with_deleted=true", admin); !found["Kept"] || !found["Binned"] {
+		t.Errorf("?with_deleted should include deleted items: %v", found)
+	}
+	if found := names("SoftDelete(only_deleted)", "/api/soft_widgets?only_deleted=true", admin); found["Kept"] || !found["Binned"] {
+		t.Errorf("?only_deleted should only have deleted items: %v", found)
+	}
+	testReqWithHeaders(t, "SoftDelete(GET with_deleted)", "GET", uri+"?with_deleted=true", "", admin, 200)
+	testReq(t, "SoftDelete(with_deleted unauthorised)", "GET", "/api/soft_widgets?with_deleted=true", "", 403)
+	testReq(t, "SoftDelete(no AuthorizeDeleted)", "GET", "/api/unauthorised_soft_widgets?only_deleted=true", "", 403)
+	testReq(t, "SoftDelete(bad with_deleted)", "GET", "/api/soft_widgets?with_deleted=maybe", "", 400)
+	testReq(t, "SoftDelete(with_deleted on hard model)", "GET", "/api/widgets?with_deleted=true", "", 400)
+
+	testReq(t, "SoftDelete(restore undeleted)", "POST", fmt.Sprintf("/api/soft_widgets/%d/restore", kept.ID), "", 404)
+	body := testReq(t, "SoftDelete(restore)", "POST", uri+"/restore", "", 200)
+	restored := SoftWidget{}
+	json.Unmarshal([]byte(body), &restored)
+	if restored.ID != binned.ID || restored.DeletedAt != nil {
+		t.Errorf("Restore returned the wrong item: %s", body)
+	}
+	testReq(t, "SoftDelete(GET restored)", "GET", uri, "", 200)
+
+	testReq(t, "SoftDelete(hard not allowed)", "DELETE", fmt.Sprintf("/api/unauthorised_soft_widgets/%d?hard=true", kept.ID), "", 403)
+	testReq(t, "SoftDelete(hard)", "DELETE", uri+"?hard=true", "", 200)
+	if !db.Unscoped().First(&SoftWidget{}, binned.ID).RecordNotFound() {
+		t.Errorf("Hard delete left the item in the database")
+	}
+	testReq(t, "SoftDelete(restore hard deleted)", "POST", uri+"/restore", "", 404)
+
+	// A soft deleted item can still be hard deleted
+	testReq(t, "SoftDelete(DELETE kept)", "DELETE", fmt.Sprintf("/api/soft_widgets/%d", kept.ID), "", 200)
+	testReq(t, "SoftDelete(hard after soft)", "DELETE", fmt.Sprintf("/api/soft_widgets/%d?hard=true", kept.ID), "", 200)
+	if !db.Unscoped().First(&SoftWidget{}, kept.ID).RecordNotFound() {
+		t.Errorf("Hard delete of a soft deleted item left it in the database")
+	}
+
+	defer ensurePanic(t, "Added a restore route for a model without DeletedAt")
+	api.AddRestoreRoute(&Widget{}, nil)
+}
+
+// Restore undoes a DELETE, so it uses the options for DELETE routes.
+func TestRestoreUsesDeleteOptions(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	db.AutoMigrate(&SoftWidget{})
+	widget := SoftWidget{Name: "Guarded"}
+	db.Create(&widget)
+	defer db.Unscoped().Delete(&widget)
+	db.Delete(&widget)
+
+	name := RouteOptions{UriModelName: "guarded_soft_widgets"}
+	deny := RouteOptions{UriModelName: "guarded_soft_widgets", Authorize: func(req ReqToAuthorize) bool {
+		return req.Fail(NewError(403, "forbidden", "Can't delete", nil))
+	}}
+	api.AddDefaultRoutes(&SoftWidget{}, name, name, deny)
+	testReq(t, "SoftDelete(restore denied)", "POST", fmt.Sprintf("/api/guarded_soft_widgets/%d/restore", widget.ID), "", 403)
+}
@@ -0,0 +1,69 @@
+package grapi
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	reservedParams["sort"] = true
+}
+
+// sortClauses turns a sort parameter such as "-created_at,name" into a list of
+// ORDER BY clauses for the table, checking each field is known and sortable.
+func (ro *RouteOptions) sortClauses(sort string, columns modelFields, tableName string) ([]string, error) {
+	clauses := make([]string, 0)
+	for _, name := range strings.Split(sort, ",") {
+		dir := "ASC"
+		if strings.HasPrefix(name, "-") {
+			dir = "DESC"
+			name = name[1:]
+		}
+		field := columns.lookup(name)
+		if field == nil || !ro.canSort(field) {
+			return nil, fmt.Errorf("Can't sort on unknown field %q", name)
+		}
+		clauses = append(clauses, fmt.Sprintf("%s.%s %s", tableName, field.DBName, dir))
+	}
+	return clauses, nil
+}
+
+// SortItems orders r.DB by the ?sort= query parameter, or by RouteOptions.DefaultSort
+// if there isn't one. Fields are separated by commas, and prefixed with "-" for
+// descending order, eg. ?sort=-created_at,name
+// Cursor paginated routes have a fixed order so can't be sorted.
+func (r *request) SortItems() bool {
+	sort := r.R.URL.Query().Get("sort")
+	if r.options.CursorPagination {
+		if sort != "" {
+			return r.badRequest(fmt.Errorf("Can't sort a route with cursor pagination"))
+		}
+		return true
+	}
+	if sort == "" {
+		sort = r.options.DefaultSort
+	}
+	if sort == "" {
+		return true
+	}
+	clauses, err := r.options.sortClauses(sort, r.columns, r.TableName)
+	if err != nil {
+		return r.badRequest(err)
+	}
+	for _, clause := range clauses {
+		r.DB = r.DB.Order(clause)
+	}
+	return true
+}
+
+// checkDefaultSort panics if RouteOptions.DefaultSort isn't valid for the model.
+func (ro *RouteOptions) checkDefaultSort(columns modelFields) {
+	if ro.DefaultSort == "" {
+		return
+	}
+	if _, err := ro.sortClauses(ro.DefaultSort, columns, ""); err != nil {
+		log.Panicf("Bad RouteOptions.DefaultSort: %v", err)
+	}
+}
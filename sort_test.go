@@ -0,0 +1,42 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// Check ?sort= and RouteOptions.DefaultSort order the index.
+func TestSortItems(t *testing.T) {
+	api := getTestApi()
+	api.AddIndexRoute(&Widget{}, &RouteOptions{UriModelName: "sorted_widgets", SortFields: []string{"name"}, DefaultSort: "-name"})
+	api.AddIndexRoute(&Widget{}, &RouteOptions{UriModelName: "sorted_cursor_widgets", CursorPagination: true})
+
+	for _, test := range []struct {
+		uri      string
+		expected []uint
+	}{
+		{"/api/widgets?id[lte]=3&sort=-id", []uint{3, 2, 1}},
+		{"/api/widgets?id[lte]=3&sort=name,-id", []uint{1, 2, 3}},
+		{"/api/sorted_widgets?id[lte]=3", []uint{3, 2, 1}},
+		{"/api/sorted_widgets?id[lte]=3&sort=name", []uint{1, 2, 3}},
+	} {
+		body := testReq(t, "Sort("+test.uri+")", "GET", test.uri, "", 200)
+		result := make([]Widget, 0)
+		json.Unmarshal([]byte(body), &result)
+		ids := make([]uint, 0)
+		for _, w := range result {
+			ids = append(ids, w.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.expected) {
+			t.Errorf("%s returned widgets in order %v, expected %v", test.uri, ids, test.expected)
+		}
+	}
+
+	testReq(t, "Sort(Unknown field)", "GET", "/api/widgets?sort=colour", "", 400)
+	testReq(t, "Sort(Not allowed)", "GET", "/api/sorted_widgets?sort=id", "", 400)
+	testReq(t, "Sort(Cursor route)", "GET", "/api/sorted_cursor_widgets?limit=2&sort=name", "", 400)
+
+	defer ensurePanic(t, "Added a route with an invalid DefaultSort")
+	api.AddIndexRoute(&Widget{}, &RouteOptions{UriModelName: "badly_sorted_widgets", DefaultSort: "colour"})
+}
@@ -0,0 +1,142 @@
+package grapi
+
+import (
+	"fmt"
+	"strconv"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// SubjectLoginModel can be used instead of LoginModel (see SetSubjectAuth) for users
+// whose ids aren't numbers, eg. UUIDs. The user's id is the subject of their tokens,
+// kept in the standard sub claim.
+type SubjectLoginModel interface {
+	//CheckLogin takes the body of the request (json deserialised to a map) and returns the user's subject or error.
+	CheckLogin(json *map[string]interface{}, g *Grapi) (string, error)
+
+	//GetBySubject returns the user with this subject, to be stored as the request's login object.
+	GetBySubject(sub string, g *Grapi) (interface{}, error)
+}
+
+// A SubjectLoginModel can also implement SubjectClaimsLoginModel to add its own
+// claims to tokens, as with ClaimsLoginModel.
+type SubjectClaimsLoginModel interface {
+	SubjectLoginModel
+
+	//SubjectClaims returns the extra claims for the token of the user with this subject.
+	SubjectClaims(sub string, g *Grapi) (map[string]interface{}, error)
+
+	//FromSubjectClaims returns the user with this subject from the claims of their token.
+	FromSubjectClaims(sub string, claims map[string]interface{}, g *Grapi) (interface{}, error)
+}
+
+// loginModelSubjects adapts a LoginModel to SubjectLoginModel, with the user's id
+// as the subject. Its tokens also have the id in the id claim, as they did before
+// there were subjects.
+type loginModelSubjects struct {
+	LoginModel
+}
+
+func (lm loginModelSubjects) CheckLogin(json *map[string]interface{}, g *Grapi) (string, error) {
+	id, err := lm.CheckLoginDetails(json, g)
+	return strconv.FormatUint(uint64(id), 10), err
+}
+
+func (lm loginModelSubjects) GetBySubject(sub string, g *Grapi) (interface{}, error) {
+	id, err := parseSubjectID(sub)
+	if err != nil {
+		return nil, err
+	}
+	return lm.GetById(id, g)
+}
+
+func (lm loginModelSubjects) SubjectClaims(sub string, g *Grapi) (map[string]interface{}, error) {
+	id, err := parseSubjectID(sub)
+	if err != nil {
+		return nil, err
+	}
+	claims := make(map[string]interface{})
+	if cm, ok := lm.LoginModel.(ClaimsLoginModel); ok {
+		if claims, err = cm.Claims(id, g); err != nil {
+			return nil, err
+		}
+		delete(claims, "id")
+	}
+	if claims == nil {
+		claims = make(map[string]interface{})
+	}
+	claims["id"] = id
+	return claims, nil
+}
+
+func (lm loginModelSubjects) FromSubjectClaims(sub string, claims map[string]interface{}, g *Grapi) (interface{}, error) {
+	id, err := parseSubjectID(sub)
+	if err != nil {
+		return nil, err
+	}
+	if cm, ok := lm.LoginModel.(ClaimsLoginModel); ok {
+		return cm.FromClaims(id, claims, g)
+	}
+	return lm.GetById(id, g)
+}
+
+// parseSubjectID converts the subject of a LoginModel's token back to its id.
+func parseSubjectID(sub string) (uint, error) {
+	id, err := strconv.ParseUint(sub, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid user id %q", sub)
+	}
+	return uint(id), nil
+}
+
+// subjectModel returns the model used for logging in, either Options.SubjectLoginModel
+// or an adapter for Options.LoginModel.
+func (g *Grapi) subjectModel() SubjectLoginModel {
+	if g.options.SubjectLoginModel != nil {
+		return g.options.SubjectLoginModel
+	}
+	return loginModelSubjects{g.options.LoginModel}
+}
+
+// userClaims returns the extra claims for the user's tokens, if the model is a
+// SubjectClaimsLoginModel (or a ClaimsLoginModel).
+func (g *Grapi) userClaims(sub string) (map[string]interface{}, error) {
+	cm, ok := g.subjectModel().(SubjectClaimsLoginModel)
+	if !ok {
+		return nil, nil
+	}
+	return cm.SubjectClaims(sub, g)
+}
+
+// loginObject returns the user a token was issued to.
+func (g *Grapi) loginObject(sub string, claims map[string]interface{}) (interface{}, error) {
+	model := g.subjectModel()
+	if cm, ok := model.(SubjectClaimsLoginModel); ok {
+		return cm.FromSubjectClaims(sub, claims, g)
+	}
+	return model.GetBySubject(sub, g)
+}
+
+// tokenSubject returns the subject of a token. Tokens issued before subjects were
+// added only have an id claim.
+func tokenSubject(token *jwt.Token) string {
+	return claimsSubject(token.Claims)
+}
+
+// claimsSubject returns the subject in the claims of a token, or "" if there is none.
+func claimsSubject(claims map[string]interface{}) string {
+	if sub, ok := claims["sub"].(string); ok {
+		return sub
+	}
+	if id, ok := claims["id"].(float64); ok {
+		return strconv.FormatUint(uint64(id), 10)
+	}
+	return ""
+}
+
+// SetSubjectAuth is SetAuth for a SubjectLoginModel.
+func (g *Grapi) SetSubjectAuth(model SubjectLoginModel, path string) {
+	g.checkAuthKeys()
+	g.options.SubjectLoginModel = model
+	g.addAuthRoutes(path)
+}
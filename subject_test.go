@@ -0,0 +1,78 @@
+package grapi
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// UUIDUser is a user keyed by a UUID rather than a number.
+type UUIDUser struct {
+	ID       string `gorm:"primary_key"`
+	Name     string
+	Password string `json:"-"`
+}
+
+func (_ *UUIDUser) CheckLogin(json *map[string]interface{}, g *Grapi) (string, error) {
+	user := UUIDUser{}
+	if g.DB().Where("name = ? AND password = ?", (*json)["name"], (*json)["password"]).Find(&user).RecordNotFound() {
+		return "", errors.New("Not authenticated")
+	}
+	return user.ID, nil
+}
+
+func (_ *UUIDUser) GetBySubject(sub string, g *Grapi) (interface{}, error) {
+	user := UUIDUser{}
+	if err := g.DB().Where("id = ?", sub).Find(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func TestSubjectAuth(t *testing.T) {
+	db := getTestDb()
+	db.DropTableIfExists(&UUIDUser{})
+	db.CreateTable(&UUIDUser{})
+	uuid := "5f0c6a3e-8a4b-4d6e-9c1f-2b7d3e4a5c6d"
+	db.Create(&UUIDUser{ID: uuid, Name: "uuid_user", Password: "password"})
+
+	api := New(Options{Db: db, UriPrefix: "/subject_api", JwtKey: "RandomString", RefreshTokenTTL: time.Hour})
+	api.SetSubjectAuth(&UUIDUser{}, "login")
+	var user *UUIDUser
+	api.AddDefaultRoutes(&Widget{}, RouteOptions{
+		UseDefaultAuth: true,
+		Authorize: func(req ReqToAuthorize) bool {
+			user = req.GetLoginObject().(*UUIDUser)
+			return true
+		}})
+
+	testApiReq(t, api, "Subject(Bad login)", "POST", "/subject_api/login", `{"name": "uuid_user", "password": "wrong"}`, 403)
+	body := testApiReq(t, api, "Subject(Login)", "POST", "/subject_api/login", `{"name": "uuid_user", "password": "password"}`, 200)
+	tokens := struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	json.Unmarshal([]byte(body), &tokens)
+	token, err := jwt.Parse(tokens.Token, func(*jwt.Token) (interface{}, error) { return []byte("RandomString"), nil })
+	if err != nil || token.Claims["sub"] != uuid || token.Claims["id"] != nil {
+		t.Errorf("The token should have the user's UUID as its subject: %v %v", token.Claims, err)
+	}
+
+	testApiReq(t, api, "Subject(GET)", "GET", "/subject_api/widgets?access_token="+tokens.Token, "", 200)
+	if user == nil || user.ID != uuid {
+		t.Errorf("The login object should be the UUID user: %+v", user)
+	}
+	body = testApiReq(t, api, "Subject(Refresh)", "POST", "/subject_api/login/refresh", `{"refresh_token": "`+tokens.RefreshToken+`"}`, 200)
+	refreshed := getToken(body)
+
+	if err := api.RevokeSubjectSessions(uuid); err != nil {
+		t.Errorf("Couldn't revoke sessions: %v", err)
+	}
+	testApiReq(t, api, "Subject(GET revoked)", "GET", "/subject_api/widgets?access_token="+refreshed, "", 401)
+
+	missing, _ := getJWTToken("00000000-0000-0000-0000-000000000000", nil, api.signingKey(), time.Hour)
+	testApiReq(t, api, "Subject(Unknown user)", "GET", "/subject_api/widgets?access_token="+missing, "", 401)
+}
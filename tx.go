@@ -0,0 +1,69 @@
+package grapi
+
+import (
+	"github.com/jinzhu/gorm"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// BeginTx starts a database transaction for a write request if the route has
+// RouteOptions.UseTransaction set, or is a bulk request. The rest of the request, including the Query
+// callback and any writes made by callbacks through req.GetTx(), runs inside it.
+// The handler must defer rollbackTx.
+func (r *request) BeginTx() bool {
+	if (!r.options.UseTransaction && !r.bulk) || r.method == "GET" {
+		return true
+	}
+	tx := r.api.db.Begin()
+	if tx.Error != nil {
+		log.WithFields(log.Fields{"error": tx.Error}).Error("Can't begin transaction")
+		return r.problem(500, "Database error")
+	}
+	r.tx = tx
+	r.DB = tx
+	return true
+}
+
+// CommitTx commits the request's transaction, if it has one. It is called once
+// every other step has succeeded, just before the result is sent.
+func (r *request) CommitTx() bool {
+	if r.tx == nil {
+		return true
+	}
+	if err := r.tx.Commit().Error; err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Can't commit transaction")
+		r.tx = nil
+		return r.problem(500, "Database error")
+	}
+	r.tx = nil
+	return true
+}
+
+// rollbackTx rolls back the request's transaction unless it has been committed.
+// Handlers defer it so that a transaction is never left open, even by a panic.
+func (r *request) rollbackTx() {
+	if r.tx == nil {
+		return
+	}
+	log.Info("Rolling back transaction")
+	r.tx.Rollback()
+	r.tx = nil
+}
+
+// GetTx returns the database transaction the request is running in, or the
+// original DB object if the route doesn't use transactions. Callbacks should
+// use it for any follow-up writes so that they are committed or rolled back
+// along with the request.
+func (r *request) GetTx() *gorm.DB {
+	return r.writeDB()
+}
+
+// writeDB returns the DB object to write to. This is the original DB object from
+// API rather than r.DB, as r.DB may have been edited with joins etc. and this
+// breaks things. If the request is in a transaction then that is used instead.
+func (r *request) writeDB() *gorm.DB {
+	if r.tx != nil {
+		return r.tx.New()
+	}
+	return r.api.db
+}
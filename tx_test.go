@@ -0,0 +1,62 @@
+package grapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestTransactions(t *testing.T) {
+	api := getTestApi()
+	db := api.DB()
+	api.AddDefaultRoutes(&Widget{}, RouteOptions{
+		UriModelName:   "tx_widgets",
+		UseTransaction: true,
+		EditResult: func(req ReqFinalResult) bool {
+			// A follow-up write, which should stand or fall with the request
+			req.GetTx().Create(&WidgetClone{Name: req.GetResult().(*Widget).Name})
+			switch req.GetRequest().URL.Query().Get("then") {
+			case "fail":
+				return req.Fail(NewError(409, "failed", "EditResult failed", nil))
+			case "panic":
+				panic("EditResult panicked")
+			}
+			return true
+		}})
+	db.DropTable(&WidgetClone{})
+	db.CreateTable(&WidgetClone{})
+
+	count := func(name string) (widgets int, clones int) {
+		db.Model(&Widget{}).Where("name = ?", name).Count(&widgets)
+		db.Model(&WidgetClone{}).Where("name = ?", name).Count(&clones)
+		return
+	}
+	body := testReq(t, "Tx(POST)", "POST", "/api/tx_widgets", `{"name":"TxCommitted"}`, 201)
+	if w, c := count("TxCommitted"); w != 1 || c != 1 {
+		t.Errorf("Transaction wasn't committed: %d widgets, %d clones", w, c)
+	}
+	testReq(t, "Tx(POST fail)", "POST", "/api/tx_widgets?then=fail", `{"name":"TxFailed"}`, 409)
+	if w, c := count("TxFailed"); w != 0 || c != 0 {
+		t.Errorf("Transaction wasn't rolled back on failure: %d widgets, %d clones", w, c)
+	}
+	testReq(t, "Tx(POST panic)", "POST", "/api/tx_widgets?then=panic", `{"name":"TxPanicked"}`, 500)
+	if w, c := count("TxPanicked"); w != 0 || c != 0 {
+		t.Errorf("Transaction wasn't rolled back on panic: %d widgets, %d clones", w, c)
+	}
+
+	widget := Widget{}
+	json.Unmarshal([]byte(body), &widget)
+	uri := fmt.Sprintf("/api/tx_widgets/%d", widget.ID)
+	testReq(t, "Tx(PATCH fail)", "PATCH", uri+"?then=fail", `{"name":"TxRenamed"}`, 409)
+	if w, _ := count("TxCommitted"); w != 1 {
+		t.Errorf("PATCH wasn't rolled back")
+	}
+	testReq(t, "Tx(DELETE fail)", "DELETE", uri+"?then=fail", "", 409)
+	if w, _ := count("TxCommitted"); w != 1 {
+		t.Errorf("DELETE wasn't rolled back")
+	}
+	testReq(t, "Tx(DELETE)", "DELETE", uri, "", 200)
+	if w, _ := count("TxCommitted"); w != 0 {
+		t.Errorf("DELETE wasn't committed")
+	}
+}
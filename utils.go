@@ -76,3 +76,18 @@ func getID(sp interface{}) (interface{}, error) {
 	}
 	return fieldByName.Interface(), nil
 }
+
+// setID sets the ID field of the structure pointer sp from the string id (eg.
+// a url param), converting it to the type of the field.
+func setID(sp interface{}, id string) error {
+	if _, err := getID(sp); err != nil {
+		return err
+	}
+	field := reflect.ValueOf(sp).Elem().FieldByName("ID")
+	v, err := (&modelField{Type: field.Type()}).parseValue(id)
+	if err != nil {
+		return err
+	}
+	field.Set(reflect.ValueOf(v).Convert(field.Type()))
+	return nil
+}